@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"poc/processor/simpleprocessor"
 	"poc/processor/taggerprocessor"
+	"poc/receiver/ec2eventsreceiver"
 )
 
 func main() {
@@ -57,6 +58,7 @@ func Components() (component.Factories, error) {
 	receivers, err := component.MakeReceiverFactoryMap(
 		hostmetricsreceiver.NewFactory(),
 		filelogreceiver.NewFactory(),
+		ec2eventsreceiver.NewFactory(),
 	)
 	errs = multierr.Append(errs, err)
 
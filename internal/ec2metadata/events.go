@@ -0,0 +1,65 @@
+package ec2metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// SpotInstanceAction is a spot interruption notice, given about two minutes before the
+// instance is stopped, hibernated or terminated.
+type SpotInstanceAction struct {
+	// Action is "stop", "hibernate" or "terminate".
+	Action string `json:"action"`
+	// Time is when the action takes place, in RFC 3339 format.
+	Time string `json:"time"`
+}
+
+// ScheduledEvent is a maintenance event scheduled for the instance.
+type ScheduledEvent struct {
+	EventID     string `json:"EventId"`
+	Code        string `json:"Code"`
+	Description string `json:"Description"`
+	State       string `json:"State"`
+	NotBefore   string `json:"NotBefore"`
+	NotAfter    string `json:"NotAfter"`
+}
+
+func (c *metadataClient) SpotInstanceAction(ctx context.Context) (*SpotInstanceAction, error) {
+	doc, err := c.metadata.GetMetadataWithContext(ctx, "spot/instance-action")
+	if err != nil {
+		// The path only exists once the instance is marked for interruption.
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var action SpotInstanceAction
+	if err := json.Unmarshal([]byte(doc), &action); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+func (c *metadataClient) ScheduledEvents(ctx context.Context) ([]ScheduledEvent, error) {
+	doc, err := c.metadata.GetMetadataWithContext(ctx, "events/maintenance/scheduled")
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var events []ScheduledEvent
+	if err := json.Unmarshal([]byte(doc), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func isNotFound(err error) bool {
+	var rerr awserr.RequestFailure
+	return errors.As(err, &rerr) && rerr.StatusCode() == http.StatusNotFound
+}
@@ -16,15 +16,32 @@ package ec2metadata // import "github.com/open-telemetry/opentelemetry-collector
 
 import (
 	"context"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
+// tagsPath lists the instance tag keys, relative to the meta-data root.
+const tagsPath = "tags/instance"
+
 type Provider interface {
 	Get(ctx context.Context) (ec2metadata.EC2InstanceIdentityDocument, error)
 	Hostname(ctx context.Context) (string, error)
 	InstanceID(ctx context.Context) (string, error)
+	// Tags returns the instance tags, which requires access to tags in instance metadata
+	// to be enabled on the instance.
+	Tags(ctx context.Context) (map[string]string, error)
+	// GetMetadata returns the value at the given path relative to /latest/meta-data/,
+	// e.g. "placement/availability-zone-id".
+	GetMetadata(ctx context.Context, path string) (string, error)
+	// SpotInstanceAction returns the pending spot interruption of the instance, or nil if
+	// there is none.
+	SpotInstanceAction(ctx context.Context) (*SpotInstanceAction, error)
+	// ScheduledEvents returns the maintenance events scheduled for the instance.
+	ScheduledEvents(ctx context.Context) ([]ScheduledEvent, error)
 }
 
 type metadataClient struct {
@@ -33,9 +50,30 @@ type metadataClient struct {
 
 var _ Provider = (*metadataClient)(nil)
 
-func NewProvider(sess *session.Session) Provider {
+// NewProvider returns a Provider backed by the instance metadata service. By default the
+// SDK behaviour is kept: IMDSv2 tokens are used when available, with a fallback to IMDSv1.
+func NewProvider(sess *session.Session, opts ...Option) Provider {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var cfgs []*aws.Config
+	if o.endpoint != "" {
+		cfgs = append(cfgs, &aws.Config{Endpoint: aws.String(o.endpoint)})
+	}
+	metadata := ec2metadata.New(sess, cfgs...)
+
+	if o.maxRetries != nil {
+		// Set after creation, since the client overrides MaxRetries with its own default.
+		metadata.Retryer = client.DefaultRetryer{NumMaxRetries: *o.maxRetries}
+	}
+	if o.requireIMDSv2 || o.tokenTTL > 0 {
+		newTokenFetcher(metadata, o).install()
+	}
+
 	return &metadataClient{
-		metadata: ec2metadata.New(sess),
+		metadata: metadata,
 	}
 }
 
@@ -50,3 +88,26 @@ func (c *metadataClient) Hostname(ctx context.Context) (string, error) {
 func (c *metadataClient) Get(ctx context.Context) (ec2metadata.EC2InstanceIdentityDocument, error) {
 	return c.metadata.GetInstanceIdentityDocumentWithContext(ctx)
 }
+
+func (c *metadataClient) GetMetadata(ctx context.Context, path string) (string, error) {
+	return c.metadata.GetMetadataWithContext(ctx, path)
+}
+
+func (c *metadataClient) Tags(ctx context.Context) (map[string]string, error) {
+	keys, err := c.metadata.GetMetadataWithContext(ctx, tagsPath)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, key := range strings.Split(keys, "\n") {
+		if key == "" {
+			continue
+		}
+		value, err := c.metadata.GetMetadataWithContext(ctx, tagsPath+"/"+key)
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
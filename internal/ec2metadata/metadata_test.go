@@ -16,13 +16,18 @@ package ec2metadata
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/awstesting/mock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMetadataProvider_get(t *testing.T) {
@@ -89,3 +94,89 @@ func TestMetadataProvider_available(t *testing.T) {
 		})
 	}
 }
+
+// newFakeIMDS serves instance-id, requiring a token on it when tokens is true.
+func newFakeIMDS(t *testing.T, tokens bool, tokenRequests *int32, ttl *string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == tokenPath:
+			atomic.AddInt32(tokenRequests, 1)
+			if !tokens {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			*ttl = r.Header.Get(ttlHeader)
+			_, _ = w.Write([]byte("token"))
+		case r.URL.Path == "/latest/meta-data/instance-id":
+			if tokens && r.Header.Get(tokenHeader) != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("i-0123456789"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMetadataProvider_imdsv2(t *testing.T) {
+	var tokenRequests int32
+	var ttl string
+	srv := newFakeIMDS(t, true, &tokenRequests, &ttl)
+
+	c := NewProvider(mock.Session, WithEndpoint(srv.URL), WithIMDSv2Required(), WithTokenTTL(5*time.Minute))
+	for i := 0; i < 2; i++ {
+		id, err := c.InstanceID(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "i-0123456789", id)
+	}
+	assert.Equal(t, "300", ttl)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&tokenRequests), "token should be reused")
+}
+
+func TestMetadataProvider_imdsv1Fallback(t *testing.T) {
+	var tokenRequests int32
+	var ttl string
+	srv := newFakeIMDS(t, false, &tokenRequests, &ttl)
+
+	c := NewProvider(mock.Session, WithEndpoint(srv.URL), WithTokenTTL(time.Minute))
+	for i := 0; i < 2; i++ {
+		id, err := c.InstanceID(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "i-0123456789", id)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&tokenRequests), "the fallback to IMDSv1 should be remembered")
+
+	_, err := NewProvider(mock.Session, WithEndpoint(srv.URL), WithIMDSv2Required(), WithMaxRetries(0)).
+		InstanceID(context.Background())
+	assert.ErrorContains(t, err, ErrIMDSv2TokenUnavailable.Error())
+}
+
+func TestMetadataProvider_tags(t *testing.T) {
+	enabled := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := map[string]string{
+			"/latest/meta-data/tags/instance":            "Name\nteam:owner",
+			"/latest/meta-data/tags/instance/Name":       "web",
+			"/latest/meta-data/tags/instance/team:owner": "core",
+		}
+		value, ok := values[r.URL.Path]
+		if !enabled || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(value))
+	}))
+	defer srv.Close()
+
+	c := NewProvider(mock.Session, WithEndpoint(srv.URL))
+	tags, err := c.Tags(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Name": "web", "team:owner": "core"}, tags)
+
+	enabled = false
+	_, err = c.Tags(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package ec2metadata
+
+import "time"
+
+type options struct {
+	requireIMDSv2 bool
+	tokenTTL      time.Duration
+	endpoint      string
+	maxRetries    *int
+}
+
+// Option configures the Provider returned by NewProvider.
+type Option func(*options)
+
+// WithIMDSv2Required makes every request use an IMDSv2 session token and fail, instead of
+// silently falling back to IMDSv1, when no token can be fetched.
+func WithIMDSv2Required() Option {
+	return func(o *options) {
+		o.requireIMDSv2 = true
+	}
+}
+
+// WithTokenTTL sets the lifetime requested for IMDSv2 session tokens. It is rounded down
+// to whole seconds and must be between 1 second and 6 hours.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.tokenTTL = ttl
+	}
+}
+
+// WithEndpoint overrides the instance metadata service endpoint, e.g. http://[fd00:ec2::254]
+// for IPv6-only instances or a local stand-in in tests.
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithMaxRetries sets how many times a failed metadata request is retried.
+func WithMaxRetries(maxRetries int) Option {
+	return func(o *options) {
+		o.maxRetries = &maxRetries
+	}
+}
@@ -0,0 +1,143 @@
+package ec2metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	tokenPath   = "/latest/api/token"
+	ttlHeader   = "x-aws-ec2-metadata-token-ttl-seconds"
+	tokenHeader = "x-aws-ec2-metadata-token"
+
+	// The SDK's handler names, replaced by install.
+	sdkFetchTokenHandlerName          = "FetchTokenHandler"
+	sdkEnableTokenProviderHandlerName = "enableTokenProviderHandler"
+
+	defaultTokenTTL = 6 * time.Hour
+	// tokenRefreshWindow is how long before its expiry a token is replaced.
+	tokenRefreshWindow = time.Minute
+	// fallbackInterval is how long requests go without a token after a failed token
+	// request when IMDSv1 is allowed, before a token is requested again.
+	fallbackInterval = 5 * time.Minute
+)
+
+// ErrIMDSv2TokenUnavailable is returned by every request of a provider that requires
+// IMDSv2 when no session token could be fetched.
+var ErrIMDSv2TokenUnavailable = errors.New("IMDSv2 session token unavailable")
+
+// tokenFetcher replaces the SDK's token handling, which always falls back to IMDSv1 and
+// uses a fixed token TTL.
+type tokenFetcher struct {
+	metadata *ec2metadata.EC2Metadata
+	required bool
+	ttl      time.Duration
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+	// fallbackErr is the last token request failure, returned without a new request
+	// until fallbackUntil when IMDSv1 is allowed.
+	fallbackErr   error
+	fallbackUntil time.Time
+}
+
+func newTokenFetcher(metadata *ec2metadata.EC2Metadata, o options) *tokenFetcher {
+	ttl := o.tokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	return &tokenFetcher{metadata: metadata, required: o.requireIMDSv2, ttl: ttl}
+}
+
+func (t *tokenFetcher) install() {
+	t.metadata.Handlers.Sign.RemoveByName(sdkFetchTokenHandlerName)
+	t.metadata.Handlers.Complete.RemoveByName(sdkEnableTokenProviderHandlerName)
+	t.metadata.Handlers.Sign.PushBackNamed(request.NamedHandler{
+		Name: "poc.ec2metadata.FetchTokenHandler",
+		Fn:   t.signRequest,
+	})
+}
+
+func (t *tokenFetcher) signRequest(r *request.Request) {
+	token, err := t.getToken(r.Context())
+	if err != nil {
+		if t.required {
+			r.Error = awserr.New(request.ErrCodeRequestError, err.Error(), err)
+		}
+		return
+	}
+	r.HTTPRequest.Header.Set(tokenHeader, token)
+}
+
+func (t *tokenFetcher) getToken(ctx context.Context) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	if t.fallbackErr != nil && time.Now().Before(t.fallbackUntil) {
+		return "", t.fallbackErr
+	}
+
+	token, err := t.fetchToken(ctx)
+	if err != nil {
+		// Without IMDSv2 required, the request falls back to IMDSv1. Remember that, so
+		// that instances with IMDSv2 unavailable do not pay for a token request, which
+		// may take until a timeout, on every metadata request.
+		if !t.required && ctx.Err() == nil {
+			t.fallbackErr = err
+			t.fallbackUntil = time.Now().Add(fallbackInterval)
+		}
+		return "", err
+	}
+	t.fallbackErr = nil
+	t.token = token
+	t.expires = time.Now().Add(t.ttl - tokenRefreshWindow)
+	return token, nil
+}
+
+func (t *tokenFetcher) fetchToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.metadata.Endpoint+tokenPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrIMDSv2TokenUnavailable, err)
+	}
+	req.Header.Set(ttlHeader, strconv.FormatInt(int64(t.ttl/time.Second), 10))
+
+	httpClient := t.metadata.Config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// A PUT response that never arrives is what a hop limit of 1 looks like from a container.
+			return "", fmt.Errorf("%w: request timed out; when running in a container, make sure the "+
+				"instance metadata hop limit (HttpPutResponseHopLimit) is at least 2: %v", ErrIMDSv2TokenUnavailable, err)
+		}
+		return "", fmt.Errorf("%w: %v", ErrIMDSv2TokenUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrIMDSv2TokenUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: unexpected status %s", ErrIMDSv2TokenUnavailable, resp.Status)
+	}
+	return string(body), nil
+}
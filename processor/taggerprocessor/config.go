@@ -1,8 +1,17 @@
 package taggerprocessor
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/config/confighttp"
+	"go.uber.org/multierr"
+
+	"poc/processor/taggerprocessor/internal"
+	"poc/processor/taggerprocessor/internal/ec2"
 )
 
 // Config defines configuration for Resource processor.
@@ -13,7 +22,137 @@ type Config struct {
 	// run to attempt to detect resource information.
 	Detectors []string `mapstructure:"detectors"`
 
+	// DetectorTimeouts optionally bounds how long each named detector may run, replacing
+	// the HTTP client timeout for it, which it may be shorter or longer than. Detectors
+	// run concurrently, so a slow detector no longer delays the others.
+	DetectorTimeouts map[string]time.Duration `mapstructure:"detector_timeouts"`
+
+	// DetectorConfig holds the user-specified configuration of each detector.
+	DetectorConfig DetectorConfig `mapstructure:",squash"`
+
+	// DetectorMergePolicy controls how the output of a detector is merged with the output
+	// of the detectors listed before it: "override" (the default), "preserve" or
+	// "fail_on_conflict", in which case the conflicting detector's output is discarded.
+	// The ec2 detector also applies it to keys set by two of its sources, e.g. a tag
+	// named InstanceId, keeping the first source under preserve and fail_on_conflict.
+	DetectorMergePolicy internal.MergePolicy `mapstructure:"detector_merge_policy"`
+
+	// MergePolicy controls how detected attributes are merged into telemetry that already
+	// carries an attribute with the same key: "override" (the default), "preserve" or
+	// "fail_on_conflict", in which case the data is rejected.
+	MergePolicy internal.MergePolicy `mapstructure:"merge_policy"`
+
+	// RefreshInterval is how often the detectors are re-run in the background so that
+	// changes such as instance re-tagging are picked up without a restart.
+	// Zero (the default) disables refreshing.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+
+	// FailOnDetectionError makes the collector fail to start when the initial detection
+	// fails and no cached resource can be used. By default the processor logs the error
+	// and passes data through unenriched.
+	FailOnDetectionError bool `mapstructure:"fail_on_detection_error"`
+
+	// RetryOnFailure configures retrying, in the background, a detection that failed at
+	// startup. Data passes through unenriched until detection succeeds.
+	RetryOnFailure internal.RetrySettings `mapstructure:"retry_on_failure"`
+
+	// Cache configures an on-disk copy of the last successfully detected resource, used
+	// at startup when detection fails and the cached instance ID matches this host.
+	Cache internal.CacheSettings `mapstructure:"cache"`
+
+	// DataPointAttributes lists detected attribute keys that are also copied onto every
+	// metric data point. Exporters such as awsemfexporter only turn data point attributes
+	// into dimensions. A single "*" copies every detected attribute; empty disables copying.
+	DataPointAttributes []string `mapstructure:"datapoint_attributes"`
+
+	// LogRecordAttributes lists detected attribute keys that are also copied onto every
+	// log record, using the same syntax as DataPointAttributes.
+	LogRecordAttributes []string `mapstructure:"log_record_attributes"`
+
 	// HTTP client settings for the detector
 	// Timeout default is 5s
 	confighttp.HTTPClientSettings `mapstructure:",squash"`
 }
+
+// Validate checks the whole configuration and returns every problem found, each
+// prefixed with the offending key.
+func (cfg *Config) Validate() error {
+	var errs error
+
+	seen := make(map[string]bool, len(cfg.Detectors))
+	for i, name := range cfg.Detectors {
+		name = strings.TrimSpace(name)
+		switch {
+		case !isKnownDetector(name):
+			errs = multierr.Append(errs, fmt.Errorf("detectors[%d]: unknown detector %q, must be one of %v", i, name, knownDetectors()))
+		case seen[name]:
+			errs = multierr.Append(errs, fmt.Errorf("detectors[%d]: duplicate detector %q", i, name))
+		}
+		seen[name] = true
+	}
+
+	timeoutNames := make([]string, 0, len(cfg.DetectorTimeouts))
+	for name := range cfg.DetectorTimeouts {
+		timeoutNames = append(timeoutNames, name)
+	}
+	sort.Strings(timeoutNames)
+	for _, name := range timeoutNames {
+		timeout := cfg.DetectorTimeouts[name]
+		name = strings.TrimSpace(name)
+		if !seen[name] {
+			errs = multierr.Append(errs, fmt.Errorf("detector_timeouts: detector %q is not listed in detectors", name))
+		}
+		if timeout < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("detector_timeouts: timeout of %q must not be negative, got %v", name, timeout))
+		}
+	}
+
+	if cfg.Timeout <= 0 {
+		errs = multierr.Append(errs, fmt.Errorf("timeout: must be positive, got %v", cfg.Timeout))
+	}
+	if cfg.RefreshInterval < 0 {
+		errs = multierr.Append(errs, fmt.Errorf("refresh_interval: must not be negative, got %v", cfg.RefreshInterval))
+	} else if cfg.RefreshInterval > 0 && cfg.RefreshInterval < cfg.Timeout {
+		errs = multierr.Append(errs, fmt.Errorf("refresh_interval: must not be shorter than timeout (%v), got %v", cfg.Timeout, cfg.RefreshInterval))
+	}
+
+	if err := cfg.DetectorMergePolicy.Validate(); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("detector_merge_policy: %w", err))
+	}
+	if err := cfg.MergePolicy.Validate(); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("merge_policy: %w", err))
+	}
+	if err := cfg.RetryOnFailure.Validate(); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("retry_on_failure: %w", err))
+	}
+	if err := cfg.Cache.Validate(); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("cache: %w", err))
+	}
+	return multierr.Append(errs, cfg.DetectorConfig.Validate())
+}
+
+// DetectorConfig contains user-specified configurations unique to all individual detectors
+type DetectorConfig struct {
+	// EC2Config contains user-specified configurations for the EC2 detector
+	EC2Config ec2.Config `mapstructure:"ec2"`
+}
+
+// Validate checks every detector configuration.
+func (d *DetectorConfig) Validate() error {
+	var errs error
+	for _, err := range multierr.Errors(d.EC2Config.Validate()) {
+		errs = multierr.Append(errs, fmt.Errorf("ec2: %w", err))
+	}
+	return errs
+}
+
+// GetConfigFromType returns the configuration of the given detector type, or nil if
+// the detector has no configuration.
+func (d *DetectorConfig) GetConfigFromType(detectorType internal.DetectorType) internal.DetectorConfig {
+	switch detectorType {
+	case ec2.TypeStr:
+		return d.EC2Config
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package taggerprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/multierr"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Detectors = []string{"ec2metadata"}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Detectors = []string{"ec2metadata", "ec2", "ec2metadata"}
+	cfg.DetectorTimeouts = map[string]time.Duration{"gcp": time.Second}
+	cfg.Timeout = 0
+	cfg.RefreshInterval = -time.Minute
+	cfg.MergePolicy = "replace"
+	cfg.DetectorConfig.EC2Config.IncludeTags = []string{"regexp:("}
+	cfg.DetectorConfig.EC2Config.MaxResults = 1
+	cfg.DetectorConfig.EC2Config.ExternalID = "tagging"
+	cfg.DetectorConfig.EC2Config.APIJitter = 10 * time.Minute
+	cfg.DetectorConfig.EC2Config.IMDS.TokenTTL = time.Millisecond
+
+	var msgs []string
+	for _, err := range multierr.Errors(cfg.Validate()) {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		`detectors[1]: unknown detector "ec2", must be one of [ec2metadata]`,
+		`detectors[2]: duplicate detector "ec2metadata"`,
+		`detector_timeouts: detector "gcp" is not listed in detectors`,
+		`timeout: must be positive, got 0s`,
+		`refresh_interval: must not be negative, got -1m0s`,
+		`merge_policy: unknown merge policy "replace", must be one of "override", "preserve" or "fail_on_conflict"`,
+		"ec2: include_tags[0]: invalid regular expression \"regexp:(\": error parsing regexp: missing closing ): `(`",
+		`ec2: max_results: must be between 5 and 500, got 1`,
+		`ec2: external_id: requires role_arn`,
+		`ec2: api_jitter: must be between 0 and 5m0s, got 10m0s`,
+		`ec2: imds: token_ttl: must be between 1s and 6h, got 1ms`,
+	}, msgs)
+}
@@ -0,0 +1,79 @@
+package taggerprocessor
+
+import (
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	// deviceAttributeKey is the data point attribute hostmetricsreceiver uses for the disk
+	// or filesystem device.
+	deviceAttributeKey = "device"
+	// ebsVolumeIDAttributeKey is the data point attribute holding the EBS volume ID.
+	ebsVolumeIDAttributeKey = "EBSVolumeId"
+)
+
+// hostmetricsDiskPrefixes are the name prefixes of the hostmetricsreceiver disk and
+// filesystem metrics.
+var hostmetricsDiskPrefixes = []string{"system.disk.", "system.filesystem."}
+
+// partitionPattern matches partition device names such as nvme0n1p1, xvda1 or sdb2 and
+// captures the name of the whole disk.
+var partitionPattern = regexp.MustCompile(`^(nvme\d+n\d+)p\d+$|^((?:xv|s)d[a-z]+)\d+$`)
+
+// addEBSVolumeIDs adds the EBSVolumeId attribute to the data points of hostmetrics disk
+// and filesystem metrics whose device is backed by an EBS volume.
+func addEBSVolumeIDs(metrics pmetric.MetricSlice, volumeDevices map[string]string) {
+	for i := 0; i < metrics.Len(); i++ {
+		metric := metrics.At(i)
+		if !isHostmetricsDiskMetric(metric.Name()) {
+			continue
+		}
+
+		var dps pmetric.NumberDataPointSlice
+		switch metric.DataType() {
+		case pmetric.MetricDataTypeGauge:
+			dps = metric.Gauge().DataPoints()
+		case pmetric.MetricDataTypeSum:
+			dps = metric.Sum().DataPoints()
+		default:
+			continue
+		}
+
+		for j := 0; j < dps.Len(); j++ {
+			attrs := dps.At(j).Attributes()
+			device, ok := attrs.Get(deviceAttributeKey)
+			if !ok {
+				continue
+			}
+			if volumeID, ok := lookupVolumeID(device.StringVal(), volumeDevices); ok {
+				attrs.InsertString(ebsVolumeIDAttributeKey, volumeID)
+			}
+		}
+	}
+}
+
+func isHostmetricsDiskMetric(name string) bool {
+	for _, prefix := range hostmetricsDiskPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupVolumeID finds the volume behind a device such as /dev/nvme1n1p1 or xvdf,
+// falling back to the whole disk for partitions.
+func lookupVolumeID(device string, volumeDevices map[string]string) (string, bool) {
+	device = strings.TrimPrefix(device, "/dev/")
+	if volumeID, ok := volumeDevices[device]; ok {
+		return volumeID, true
+	}
+	if match := partitionPattern.FindStringSubmatch(device); match != nil {
+		volumeID, ok := volumeDevices[match[1]+match[2]]
+		return volumeID, ok
+	}
+	return "", false
+}
@@ -0,0 +1,109 @@
+package taggerprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestPartitionPattern(t *testing.T) {
+	tests := []struct {
+		device string
+		disk   string
+	}{
+		{device: "nvme0n1p1", disk: "nvme0n1"},
+		{device: "nvme12n3p15", disk: "nvme12n3"},
+		{device: "xvda1", disk: "xvda"},
+		{device: "xvdba2", disk: "xvdba"},
+		{device: "sdb2", disk: "sdb"},
+		{device: "nvme0n1"},
+		{device: "xvdf"},
+		{device: "sdb"},
+		{device: "loop0"},
+		{device: "dm-0"},
+		{device: "md127"},
+		{device: "hda1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			match := partitionPattern.FindStringSubmatch(tt.device)
+			if tt.disk == "" {
+				assert.Nil(t, match)
+				return
+			}
+			if assert.NotNil(t, match) {
+				assert.Equal(t, tt.disk, match[1]+match[2])
+			}
+		})
+	}
+}
+
+func TestLookupVolumeID(t *testing.T) {
+	volumeDevices := map[string]string{
+		"nvme1n1": "vol-nvme",
+		"xvdf":    "vol-xvdf",
+		"sdf":     "vol-xvdf",
+	}
+	tests := []struct {
+		device   string
+		volumeID string
+	}{
+		{device: "nvme1n1", volumeID: "vol-nvme"},
+		{device: "/dev/nvme1n1", volumeID: "vol-nvme"},
+		{device: "/dev/nvme1n1p2", volumeID: "vol-nvme"},
+		{device: "xvdf", volumeID: "vol-xvdf"},
+		{device: "sdf1", volumeID: "vol-xvdf"},
+		{device: "/dev/nvme0n1p1"},
+		{device: "xvdg"},
+		{device: "tmpfs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			volumeID, ok := lookupVolumeID(tt.device, volumeDevices)
+			assert.Equal(t, tt.volumeID != "", ok)
+			assert.Equal(t, tt.volumeID, volumeID)
+		})
+	}
+}
+
+func TestAddEBSVolumeIDs(t *testing.T) {
+	metrics := pmetric.NewMetricSlice()
+	newMetric := func(name string, dataType pmetric.MetricDataType, device string) pmetric.NumberDataPoint {
+		metric := metrics.AppendEmpty()
+		metric.SetName(name)
+		metric.SetDataType(dataType)
+		var dp pmetric.NumberDataPoint
+		if dataType == pmetric.MetricDataTypeGauge {
+			dp = metric.Gauge().DataPoints().AppendEmpty()
+		} else {
+			dp = metric.Sum().DataPoints().AppendEmpty()
+		}
+		if device != "" {
+			dp.Attributes().InsertString(deviceAttributeKey, device)
+		}
+		return dp
+	}
+	diskIO := newMetric("system.disk.io", pmetric.MetricDataTypeSum, "nvme1n1")
+	usage := newMetric("system.filesystem.usage", pmetric.MetricDataTypeSum, "/dev/nvme1n1p1")
+	utilization := newMetric("system.filesystem.utilization", pmetric.MetricDataTypeGauge, "/dev/nvme1n1p1")
+	unknownDevice := newMetric("system.disk.io", pmetric.MetricDataTypeSum, "loop0")
+	noDevice := newMetric("system.disk.operations", pmetric.MetricDataTypeSum, "")
+	otherMetric := newMetric("system.network.io", pmetric.MetricDataTypeSum, "nvme1n1")
+	histogram := metrics.AppendEmpty()
+	histogram.SetName("system.disk.latency")
+	histogram.SetDataType(pmetric.MetricDataTypeHistogram)
+	histogram.Histogram().DataPoints().AppendEmpty().Attributes().InsertString(deviceAttributeKey, "nvme1n1")
+
+	addEBSVolumeIDs(metrics, map[string]string{"nvme1n1": "vol-1"})
+
+	assert.Equal(t, map[string]interface{}{deviceAttributeKey: "nvme1n1", ebsVolumeIDAttributeKey: "vol-1"}, diskIO.Attributes().AsRaw())
+	partition := map[string]interface{}{deviceAttributeKey: "/dev/nvme1n1p1", ebsVolumeIDAttributeKey: "vol-1"}
+	assert.Equal(t, partition, usage.Attributes().AsRaw())
+	assert.Equal(t, partition, utilization.Attributes().AsRaw())
+	assert.Equal(t, map[string]interface{}{deviceAttributeKey: "loop0"}, unknownDevice.Attributes().AsRaw())
+	assert.Equal(t, 0, noDevice.Attributes().Len())
+	assert.Equal(t, map[string]interface{}{deviceAttributeKey: "nvme1n1"}, otherMetric.Attributes().AsRaw())
+	assert.Equal(t, map[string]interface{}{deviceAttributeKey: "nvme1n1"},
+		histogram.Histogram().DataPoints().At(0).Attributes().AsRaw())
+}
@@ -2,6 +2,7 @@ package taggerprocessor
 
 import (
 	"context"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,30 +26,58 @@ const (
 
 var consumerCapabilities = consumer.Capabilities{MutatesData: true}
 
+// detectorFactories holds every detector the processor can run, keyed by the name used
+// in the detectors setting.
+var detectorFactories = map[internal.DetectorType]internal.DetectorFactory{
+	ec2.TypeStr: ec2.NewDetector,
+}
+
+func isKnownDetector(name string) bool {
+	_, ok := detectorFactories[internal.DetectorType(name)]
+	return ok
+}
+
+func knownDetectors() []string {
+	names := make([]string, 0, len(detectorFactories))
+	for detectorType := range detectorFactories {
+		names = append(names, string(detectorType))
+	}
+	sort.Strings(names)
+	return names
+}
+
 type factory struct {
 	resourceProviderFactory *internal.ResourceProviderFactory
 
 	// providers stores a provider for each named processor that
-	// may a different set of detectors configured.
-	providers map[config.ComponentID]*internal.ResourceProvider
+	// may a different set of detectors configured. A provider is shared by
+	// the metrics, traces and logs processors of the same ID and is shut
+	// down when the last of them is.
+	providers map[config.ComponentID]*sharedProvider
 	lock      sync.Mutex
 }
 
+// sharedProvider counts the processors using a provider.
+type sharedProvider struct {
+	provider *internal.ResourceProvider
+	refs     int
+}
+
 // NewFactory creates a new factory for ResourceDetection processor.
 func NewFactory() component.ProcessorFactory {
-	resourceProviderFactory := internal.NewProviderFactory(map[internal.DetectorType]internal.DetectorFactory{
-		ec2.TypeStr: ec2.NewDetector,
-	})
+	resourceProviderFactory := internal.NewProviderFactory(detectorFactories)
 
 	f := &factory{
 		resourceProviderFactory: resourceProviderFactory,
-		providers:               map[config.ComponentID]*internal.ResourceProvider{},
+		providers:               map[config.ComponentID]*sharedProvider{},
 	}
 
 	return component.NewProcessorFactory(
 		typeStr,
 		createDefaultConfig,
-		component.WithMetricsProcessor(f.createMetricsProcessor, stability))
+		component.WithMetricsProcessor(f.createMetricsProcessor, stability),
+		component.WithTracesProcessor(f.createTracesProcessor, stability),
+		component.WithLogsProcessor(f.createLogsProcessor, stability))
 }
 
 // Type gets the type of the Option config created by this factory.
@@ -58,9 +87,13 @@ func (*factory) Type() config.Type {
 
 func createDefaultConfig() config.Processor {
 	return &Config{
-		ProcessorSettings:  config.NewProcessorSettings(config.NewComponentID(typeStr)),
-		Detectors:          []string{},
-		HTTPClientSettings: defaultHTTPClientSettings(),
+		ProcessorSettings:   config.NewProcessorSettings(config.NewComponentID(typeStr)),
+		Detectors:           []string{},
+		DetectorConfig:      DetectorConfig{EC2Config: ec2.CreateDefaultConfig()},
+		DetectorMergePolicy: internal.MergePolicyOverride,
+		MergePolicy:         internal.MergePolicyOverride,
+		RetryOnFailure:      internal.NewDefaultRetrySettings(),
+		HTTPClientSettings:  defaultHTTPClientSettings(),
 	}
 }
 
@@ -81,12 +114,68 @@ func (f *factory) createMetricsProcessor(
 		return nil, err
 	}
 
-	return processorhelper.NewMetricsProcessor(
+	processor, err := processorhelper.NewMetricsProcessor(
 		cfg,
 		nextConsumer,
 		rdp.processMetrics,
 		processorhelper.WithCapabilities(consumerCapabilities),
-		processorhelper.WithStart(rdp.Start))
+		processorhelper.WithStart(rdp.Start),
+		processorhelper.WithShutdown(rdp.Shutdown))
+	if err != nil {
+		_ = rdp.Shutdown(context.Background())
+		return nil, err
+	}
+	return processor, nil
+}
+
+func (f *factory) createTracesProcessor(
+	_ context.Context,
+	params component.ProcessorCreateSettings,
+	cfg config.Processor,
+	nextConsumer consumer.Traces,
+) (component.TracesProcessor, error) {
+	rdp, err := f.getResourceDetectionProcessor(params, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := processorhelper.NewTracesProcessor(
+		cfg,
+		nextConsumer,
+		rdp.processTraces,
+		processorhelper.WithCapabilities(consumerCapabilities),
+		processorhelper.WithStart(rdp.Start),
+		processorhelper.WithShutdown(rdp.Shutdown))
+	if err != nil {
+		_ = rdp.Shutdown(context.Background())
+		return nil, err
+	}
+	return processor, nil
+}
+
+func (f *factory) createLogsProcessor(
+	_ context.Context,
+	params component.ProcessorCreateSettings,
+	cfg config.Processor,
+	nextConsumer consumer.Logs,
+) (component.LogsProcessor, error) {
+	rdp, err := f.getResourceDetectionProcessor(params, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := processorhelper.NewLogsProcessor(
+		cfg,
+		nextConsumer,
+		rdp.processLogs,
+		processorhelper.WithCapabilities(consumerCapabilities),
+		processorhelper.WithStart(rdp.Start),
+		processorhelper.WithShutdown(rdp.Shutdown))
+	if err != nil {
+		_ = rdp.Shutdown(context.Background())
+		return nil, err
+	}
+	return processor, nil
 }
 
 func (f *factory) getResourceDetectionProcessor(
@@ -95,41 +184,89 @@ func (f *factory) getResourceDetectionProcessor(
 ) (*resourceDetectionProcessor, error) {
 	oCfg := cfg.(*Config)
 
-	provider, err := f.getResourceProvider(params, cfg.ID(), oCfg.HTTPClientSettings.Timeout, oCfg.Detectors)
+	provider, err := f.getResourceProvider(params, cfg.ID(), oCfg)
 	if err != nil {
 		return nil, err
 	}
 
 	return &resourceDetectionProcessor{
-		provider:           provider,
-		httpClientSettings: oCfg.HTTPClientSettings,
-		telemetrySettings:  params.TelemetrySettings,
+		provider: provider,
+		release: func(ctx context.Context) error {
+			return f.releaseResourceProvider(ctx, cfg.ID())
+		},
+		dataPointAttributes:  oCfg.DataPointAttributes,
+		logRecordAttributes:  oCfg.LogRecordAttributes,
+		mergePolicy:          oCfg.MergePolicy,
+		failOnDetectionError: oCfg.FailOnDetectionError,
+		logger:               params.Logger,
+		httpClientSettings:   oCfg.HTTPClientSettings,
+		telemetrySettings:    params.TelemetrySettings,
 	}, nil
 }
 
 func (f *factory) getResourceProvider(
 	params component.ProcessorCreateSettings,
 	processorName config.ComponentID,
-	timeout time.Duration,
-	configuredDetectors []string,
+	oCfg *Config,
 ) (*internal.ResourceProvider, error) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
-	if provider, ok := f.providers[processorName]; ok {
-		return provider, nil
+	if shared, ok := f.providers[processorName]; ok {
+		shared.refs++
+		return shared.provider, nil
 	}
 
-	detectorTypes := make([]internal.DetectorType, 0, len(configuredDetectors))
-	for _, key := range configuredDetectors {
+	detectorTypes := make([]internal.DetectorType, 0, len(oCfg.Detectors))
+	for _, key := range oCfg.Detectors {
 		detectorTypes = append(detectorTypes, internal.DetectorType(strings.TrimSpace(key)))
 	}
 
-	provider, err := f.resourceProviderFactory.CreateResourceProvider(params, timeout, detectorTypes...)
+	detectorTimeouts := make(map[internal.DetectorType]time.Duration, len(oCfg.DetectorTimeouts))
+	for key, detectorTimeout := range oCfg.DetectorTimeouts {
+		detectorTimeouts[internal.DetectorType(strings.TrimSpace(key))] = detectorTimeout
+	}
+
+	settings := internal.ProviderSettings{
+		Timeout:          oCfg.HTTPClientSettings.Timeout,
+		RefreshInterval:  oCfg.RefreshInterval,
+		MergePolicy:      oCfg.DetectorMergePolicy,
+		DetectorTimeouts: detectorTimeouts,
+		Retry:            oCfg.RetryOnFailure,
+		Cache:            oCfg.Cache,
+		FailOnError:      oCfg.FailOnDetectionError,
+	}
+
+	// The detector merge policy also applies between the sources of a single detector.
+	detectorConfig := oCfg.DetectorConfig
+	detectorConfig.EC2Config.MergePolicy = oCfg.DetectorMergePolicy
+
+	provider, err := f.resourceProviderFactory.CreateResourceProvider(params, settings, &detectorConfig, detectorTypes...)
 	if err != nil {
 		return nil, err
 	}
 
-	f.providers[processorName] = provider
+	f.providers[processorName] = &sharedProvider{provider: provider, refs: 1}
 	return provider, nil
 }
+
+// releaseResourceProvider drops one reference to the provider of the named processor.
+// The last reference shuts the provider down and forgets it, so that a rebuilt
+// pipeline gets a fresh provider with the current configuration.
+func (f *factory) releaseResourceProvider(ctx context.Context, processorName config.ComponentID) error {
+	f.lock.Lock()
+	shared, ok := f.providers[processorName]
+	if !ok {
+		f.lock.Unlock()
+		return nil
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		f.lock.Unlock()
+		return nil
+	}
+	delete(f.providers, processorName)
+	f.lock.Unlock()
+
+	return shared.provider.Shutdown(ctx)
+}
@@ -0,0 +1,47 @@
+package taggerprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+
+	"poc/processor/taggerprocessor/internal"
+)
+
+func TestFactory_sharesProviderUntilLastShutdown(t *testing.T) {
+	f := &factory{
+		resourceProviderFactory: internal.NewProviderFactory(detectorFactories),
+		providers:               map[config.ComponentID]*sharedProvider{},
+	}
+	cfg := createDefaultConfig().(*Config)
+	cfg.Detectors = []string{"ec2metadata"}
+	params := componenttest.NewNopProcessorCreateSettings()
+
+	mp, err := f.createMetricsProcessor(context.Background(), params, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	tp, err := f.createTracesProcessor(context.Background(), params, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+
+	providers := func() map[string]int {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		refs := map[string]int{}
+		for id, shared := range f.providers {
+			refs[id.String()] = shared.refs
+		}
+		return refs
+	}
+	assert.Equal(t, map[string]int{"taggerprocessor": 2}, providers())
+
+	require.NoError(t, mp.Shutdown(context.Background()))
+	require.NoError(t, mp.Shutdown(context.Background()))
+	assert.Equal(t, map[string]int{"taggerprocessor": 1}, providers())
+
+	require.NoError(t, tp.Shutdown(context.Background()))
+	assert.Empty(t, providers())
+}
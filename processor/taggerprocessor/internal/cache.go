@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// CacheSettings configures the on-disk cache of the last successfully detected resource.
+type CacheSettings struct {
+	// Path is the file the detected resource is saved to. Empty disables the cache.
+	Path string `mapstructure:"path"`
+	// MaxAge is how old a cached resource may be and still be used. Zero means no limit.
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// Validate checks that the cache settings are consistent.
+func (s CacheSettings) Validate() error {
+	if s.MaxAge < 0 {
+		return fmt.Errorf("max_age must not be negative, got %v", s.MaxAge)
+	}
+	return nil
+}
+
+// InstanceIdentifier is implemented by detectors that can cheaply identify the host
+// they run on. The provider uses it to check that a cached resource belongs to this host.
+type InstanceIdentifier interface {
+	InstanceID(ctx context.Context) (string, error)
+}
+
+type cachedResource struct {
+	Timestamp     time.Time              `json:"timestamp"`
+	InstanceID    string                 `json:"instance_id"`
+	SchemaURL     string                 `json:"schema_url,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes"`
+	VolumeDevices map[string]string      `json:"volume_devices,omitempty"`
+}
+
+// saveCachedResource atomically writes result to path.
+func saveCachedResource(path string, instanceID string, result *resourceResult) error {
+	data, err := json.Marshal(cachedResource{
+		Timestamp:     time.Now(),
+		InstanceID:    instanceID,
+		SchemaURL:     result.schemaURL,
+		Attributes:    AttributesToMap(result.resource.Attributes()),
+		VolumeDevices: result.volumeDevices,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCachedResource reads the resource cached at path. It fails if the cache belongs
+// to a different instance or is older than maxAge.
+func loadCachedResource(path string, instanceID string, maxAge time.Duration) (*resourceResult, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var cached cachedResource
+	if err = json.Unmarshal(data, &cached); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid cache file: %w", err)
+	}
+	if cached.InstanceID == "" || cached.InstanceID != instanceID {
+		return nil, time.Time{}, fmt.Errorf("cache belongs to instance %q, not %q", cached.InstanceID, instanceID)
+	}
+	if maxAge > 0 && time.Since(cached.Timestamp) > maxAge {
+		return nil, time.Time{}, errors.New("cache has expired")
+	}
+
+	res := pcommon.NewResource()
+	pcommon.NewMapFromRaw(cached.Attributes).CopyTo(res.Attributes())
+	return &resourceResult{resource: res, schemaURL: cached.SchemaURL, volumeDevices: cached.VolumeDevices}, cached.Timestamp, nil
+}
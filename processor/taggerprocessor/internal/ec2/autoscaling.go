@@ -0,0 +1,63 @@
+package ec2
+
+import (
+	"context"
+	"strings"
+
+	ec2provider "poc/internal/ec2metadata"
+)
+
+// Auto Scaling attribute keys in the CloudWatch style.
+const (
+	MetadataKeyAutoScalingGroupName  = "AutoScalingGroupName"
+	MetadataKeyLaunchTemplateId      = "LaunchTemplateId"
+	MetadataKeyLaunchTemplateVersion = "LaunchTemplateVersion"
+	MetadataKeyLifecycleState        = "LifecycleState"
+)
+
+// Auto Scaling attribute keys in the semantic convention style.
+const (
+	attributeAWSAutoScalingGroupName      = "aws.autoscaling.group.name"
+	attributeAWSEC2LaunchTemplateID       = "aws.ec2.launch_template.id"
+	attributeAWSEC2LaunchTemplateVersion  = "aws.ec2.launch_template.version"
+	attributeAWSAutoScalingLifecycleState = "aws.autoscaling.lifecycle_state"
+)
+
+// autoScalingTags maps the tags EC2 Auto Scaling and launch templates put on an instance
+// to the attributes they are emitted as.
+var autoScalingTags = map[string]struct{ cloudWatchKey, semconvKey string }{
+	"aws:autoscaling:groupName":     {MetadataKeyAutoScalingGroupName, attributeAWSAutoScalingGroupName},
+	"aws:ec2launchtemplate:id":      {MetadataKeyLaunchTemplateId, attributeAWSEC2LaunchTemplateID},
+	"aws:ec2launchtemplate:version": {MetadataKeyLaunchTemplateVersion, attributeAWSEC2LaunchTemplateVersion},
+}
+
+// addAutoScalingTag adds the normalized attribute of an Auto Scaling or launch template
+// tag to attrs, whether or not the tag itself passes the tag filter. It reports whether
+// the tag was one of them.
+func addAutoScalingTag(attrs map[string]string, key, value string, keyStyle KeyStyle) bool {
+	keys, ok := autoScalingTags[key]
+	if !ok {
+		return false
+	}
+	if keyStyle.cloudWatchKeys() {
+		attrs[keys.cloudWatchKey] = value
+	}
+	if keyStyle.semconvKeys() {
+		attrs[keys.semconvKey] = value
+	}
+	return true
+}
+
+// detectLifecycleState returns the target lifecycle state of the instance in its Auto
+// Scaling group, e.g. "InService" or "Warmed:Terminated", or "" if the instance is not
+// in a group.
+func detectLifecycleState(ctx context.Context, provider ec2provider.Provider) (string, error) {
+	state, err := provider.GetMetadata(ctx, "autoscaling/target-lifecycle-state")
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(state), nil
+}
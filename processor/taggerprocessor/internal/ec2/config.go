@@ -1,5 +1,256 @@
 package ec2
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+
+	ec2provider "poc/internal/ec2metadata"
+	"poc/processor/taggerprocessor/internal"
+)
+
+// KeyStyle selects the attribute keys the EC2 detector emits.
+type KeyStyle string
+
+const (
+	// KeyStyleCloudWatch emits CloudWatch agent style keys such as InstanceId. These
+	// keys are not part of any OpenTelemetry schema, so no schema URL is reported.
+	KeyStyleCloudWatch KeyStyle = "cloudwatch"
+	// KeyStyleSemconv emits OpenTelemetry semantic convention keys such as host.id and
+	// reports the schema URL of the semantic conventions they follow.
+	KeyStyleSemconv KeyStyle = "semconv"
+	// KeyStyleBoth emits both sets of keys and reports the semantic conventions schema
+	// URL, which describes the semantic convention keys only.
+	KeyStyleBoth KeyStyle = "both"
+)
+
+// TagSource selects where the EC2 detector reads instance tags from.
+type TagSource string
+
+const (
+	// TagSourceAPI reads tags with the DescribeTags API, which needs ec2:DescribeTags.
+	TagSourceAPI TagSource = "api"
+	// TagSourceIMDS reads tags from instance metadata, which needs no IAM permission but
+	// requires access to tags in instance metadata to be enabled on the instance.
+	TagSourceIMDS TagSource = "imds"
+	// TagSourceIMDSThenAPI reads tags from instance metadata and falls back to the API
+	// when they are not available there.
+	TagSourceIMDSThenAPI TagSource = "imds_then_api"
+)
+
 // Config defines user-specified configurations unique to the EC2 detector
 type Config struct {
+	// IncludeTags lists the instance tag keys to add to the resource. Each entry is an
+	// exact key, a glob such as "team:*", or a regular expression prefixed with "regexp:".
+	// A single "*" includes every tag, which is the default.
+	IncludeTags []string `mapstructure:"include_tags"`
+
+	// ExcludeTags lists tag keys to drop even when they match IncludeTags, using the
+	// same syntax, e.g. "aws:cloudformation:*".
+	ExcludeTags []string `mapstructure:"exclude_tags"`
+
+	// TagSource selects where instance tags are read from: "api" (the default), "imds"
+	// or "imds_then_api". Attached volumes are always read from the API.
+	TagSource TagSource `mapstructure:"tag_source"`
+
+	// KeyStyle selects the keys used for the instance identity attributes:
+	// "cloudwatch" (the default), "semconv" or "both".
+	KeyStyle KeyStyle `mapstructure:"key_style"`
+
+	// MaxResults is the page size requested from DescribeTags and DescribeVolumes,
+	// between 5 and 500. Zero uses the API default.
+	MaxResults int64 `mapstructure:"max_results"`
+
+	// MaxPages caps how many pages are read from each API call. Zero means no limit.
+	MaxPages int `mapstructure:"max_pages"`
+
+	// ResolveEBSDevices maps the local device name of each attached EBS volume, including
+	// NVMe devices on Nitro instances, to its volume ID so that the processor can add an
+	// EBSVolumeId attribute to hostmetrics disk and filesystem metrics.
+	ResolveEBSDevices bool `mapstructure:"resolve_ebs_devices"`
+
+	// Region overrides the region of the EC2 API endpoint, which defaults to the region
+	// reported by the instance identity document.
+	Region string `mapstructure:"region"`
+
+	// Profile is the shared config profile whose credentials are used to call the EC2 API,
+	// read from ~/.aws/config as well as ~/.aws/credentials, so that a profile may use
+	// source_profile, credential_process or SSO. Empty uses the default credential chain.
+	Profile string `mapstructure:"profile"`
+
+	// SharedCredentialsFile reads the credentials of Profile from this file instead of
+	// ~/.aws/credentials.
+	SharedCredentialsFile string `mapstructure:"shared_credentials_file"`
+
+	// RoleARN is an IAM role assumed through STS to call the EC2 API, e.g. a read-only
+	// tagging role. The credentials above are used to assume it.
+	RoleARN string `mapstructure:"role_arn"`
+
+	// ExternalID is passed when assuming RoleARN, if the role's trust policy requires one.
+	ExternalID string `mapstructure:"external_id"`
+
+	// Endpoint overrides the endpoint of both the EC2 API and the instance metadata
+	// service, e.g. a local stand-in serving both. With a VPC interface endpoint for the
+	// EC2 API, such as "https://vpce-0123-abcd.ec2.us-east-1.vpce.amazonaws.com", set
+	// imds.endpoint to the metadata service address, "http://169.254.169.254". STS, used
+	// to assume role_arn, is not affected.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// UseFIPSEndpoint calls the FIPS variant of the regional EC2 API endpoint.
+	UseFIPSEndpoint bool `mapstructure:"use_fips_endpoint"`
+
+	// UseDualStackEndpoint calls the dual-stack (IPv4 and IPv6) variant of the regional
+	// EC2 API endpoint.
+	UseDualStackEndpoint bool `mapstructure:"use_dualstack_endpoint"`
+
+	// Network selects the network placement attributes to add, such as the subnet ID.
+	Network NetworkConfig `mapstructure:"network"`
+
+	// AutoScaling adds the Auto Scaling group name and the launch template ID and version
+	// from the instance tags, whatever the tag filters, and the target lifecycle state of
+	// the instance from instance metadata, e.g. "InService" or "Warmed:Terminated". The
+	// state is kept current when refresh_interval is set.
+	AutoScaling bool `mapstructure:"autoscaling"`
+
+	// APIRateLimit caps the EC2 API calls per second made by the detectors of the
+	// collector, which share one limit; the lowest limit configured applies. The rate is
+	// lowered automatically while calls are throttled. Zero disables the limit.
+	APIRateLimit float64 `mapstructure:"api_rate_limit"`
+
+	// APIJitter is the longest random delay before the detector's first EC2 API call, so
+	// that a fleet started at once spreads its calls. It is off by default and may be at
+	// most 5m. The delay counts against the detector timeout and is cut to half of it, so
+	// raise detector_timeouts for ec2metadata along with it.
+	APIJitter time.Duration `mapstructure:"api_jitter"`
+
+	// IMDS configures the client of the instance metadata service.
+	IMDS IMDSConfig `mapstructure:"imds"`
+
+	// MergePolicy is the processor's detector_merge_policy. It also resolves keys that
+	// two sources of the detector both set, e.g. a tag named InstanceId, with the source
+	// read first winning under preserve and fail_on_conflict.
+	MergePolicy internal.MergePolicy `mapstructure:"-"`
+}
+
+// IMDSConfig configures how the EC2 detector talks to the instance metadata service.
+type IMDSConfig struct {
+	// RequireIMDSv2 fails metadata requests when no IMDSv2 session token can be fetched
+	// instead of falling back to IMDSv1. Enable it on instances where IMDSv1 is disabled.
+	RequireIMDSv2 bool `mapstructure:"require_imdsv2"`
+
+	// TokenTTL is the lifetime requested for IMDSv2 session tokens, between 1s and 6h.
+	// Zero uses the SDK default of 6h.
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+
+	// Endpoint overrides the metadata service endpoint, e.g. "http://[fd00:ec2::254]".
+	// It defaults to the detector's endpoint, if set.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// MaxRetries is how many times a failed metadata request is retried. Unset uses the
+	// SDK default.
+	MaxRetries *int `mapstructure:"max_retries"`
+}
+
+func (cfg IMDSConfig) validate() (errs error) {
+	if cfg.TokenTTL != 0 && (cfg.TokenTTL < time.Second || cfg.TokenTTL > 6*time.Hour) {
+		errs = multierr.Append(errs, fmt.Errorf("imds: token_ttl: must be between 1s and 6h, got %v", cfg.TokenTTL))
+	}
+	if cfg.Endpoint != "" && !isHTTPURL(cfg.Endpoint) {
+		errs = multierr.Append(errs, fmt.Errorf("imds: endpoint: must be an absolute http or https URL, got %q", cfg.Endpoint))
+	}
+	if cfg.MaxRetries != nil && *cfg.MaxRetries < 0 {
+		errs = multierr.Append(errs, fmt.Errorf("imds: max_retries: must not be negative, got %d", *cfg.MaxRetries))
+	}
+	return errs
+}
+
+// providerOptions returns the metadata provider options, using endpoint unless the
+// metadata service endpoint is set.
+func (cfg IMDSConfig) providerOptions(endpoint string) []ec2provider.Option {
+	if cfg.Endpoint != "" {
+		endpoint = cfg.Endpoint
+	}
+	var opts []ec2provider.Option
+	if cfg.RequireIMDSv2 {
+		opts = append(opts, ec2provider.WithIMDSv2Required())
+	}
+	if cfg.TokenTTL > 0 {
+		opts = append(opts, ec2provider.WithTokenTTL(cfg.TokenTTL))
+	}
+	if endpoint != "" {
+		opts = append(opts, ec2provider.WithEndpoint(endpoint))
+	}
+	if cfg.MaxRetries != nil {
+		opts = append(opts, ec2provider.WithMaxRetries(*cfg.MaxRetries))
+	}
+	return opts
+}
+
+// CreateDefaultConfig returns the default configuration of the EC2 detector.
+func CreateDefaultConfig() Config {
+	return Config{
+		IncludeTags:  []string{"*"},
+		TagSource:    TagSourceAPI,
+		KeyStyle:     KeyStyleCloudWatch,
+		MaxPages:     20,
+		APIRateLimit: defaultAPIRateLimit,
+	}
+}
+
+// Validate checks the tag filter patterns, paging limits, tag source, key style, role,
+// endpoints and IMDS settings, returning every problem found.
+func (cfg Config) Validate() error {
+	_, errs := newTagFilter(cfg.IncludeTags, cfg.ExcludeTags)
+	if cfg.MaxResults != 0 && (cfg.MaxResults < 5 || cfg.MaxResults > 500) {
+		errs = multierr.Append(errs, fmt.Errorf("max_results: must be between 5 and 500, got %d", cfg.MaxResults))
+	}
+	if cfg.MaxPages < 0 {
+		errs = multierr.Append(errs, fmt.Errorf("max_pages: must not be negative, got %d", cfg.MaxPages))
+	}
+	switch cfg.TagSource {
+	case "", TagSourceAPI, TagSourceIMDS, TagSourceIMDSThenAPI:
+	default:
+		errs = multierr.Append(errs, fmt.Errorf("tag_source: unknown tag source %q, must be one of %q, %q or %q",
+			cfg.TagSource, TagSourceAPI, TagSourceIMDS, TagSourceIMDSThenAPI))
+	}
+	switch cfg.KeyStyle {
+	case "", KeyStyleCloudWatch, KeyStyleSemconv, KeyStyleBoth:
+	default:
+		errs = multierr.Append(errs, fmt.Errorf("key_style: unknown key style %q, must be one of %q, %q or %q",
+			cfg.KeyStyle, KeyStyleCloudWatch, KeyStyleSemconv, KeyStyleBoth))
+	}
+	if cfg.RoleARN != "" && !strings.HasPrefix(cfg.RoleARN, "arn:") {
+		errs = multierr.Append(errs, fmt.Errorf("role_arn: must be an IAM role ARN, got %q", cfg.RoleARN))
+	}
+	if cfg.ExternalID != "" && cfg.RoleARN == "" {
+		errs = multierr.Append(errs, errors.New("external_id: requires role_arn"))
+	}
+	if cfg.APIRateLimit < 0 {
+		errs = multierr.Append(errs, fmt.Errorf("api_rate_limit: must not be negative, got %v", cfg.APIRateLimit))
+	}
+	if cfg.APIJitter < 0 || cfg.APIJitter > maxAPIJitter {
+		errs = multierr.Append(errs, fmt.Errorf("api_jitter: must be between 0 and %v, got %v", maxAPIJitter, cfg.APIJitter))
+	}
+	if cfg.Endpoint != "" && !isHTTPURL(cfg.Endpoint) {
+		errs = multierr.Append(errs, fmt.Errorf("endpoint: must be an absolute http or https URL, got %q", cfg.Endpoint))
+	}
+	errs = multierr.Append(errs, cfg.IMDS.validate())
+	return errs
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s KeyStyle) cloudWatchKeys() bool {
+	return s == "" || s == KeyStyleCloudWatch || s == KeyStyleBoth
+}
+
+func (s KeyStyle) semconvKeys() bool {
+	return s == KeyStyleSemconv || s == KeyStyleBoth
 }
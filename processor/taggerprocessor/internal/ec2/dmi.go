@@ -0,0 +1,43 @@
+package ec2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultDMIPath = "/sys/class/dmi/id"
+
+// isEC2Host reports whether the DMI data under dmiPath identifies the host as an EC2
+// instance: Nitro instances report "Amazon EC2" as their system vendor and Xen
+// instances an Amazon BIOS version. It is false when the data cannot be read, e.g. on
+// other operating systems or in containers without /sys.
+func isEC2Host(dmiPath string) bool {
+	if vendor, err := readDMI(dmiPath, "sys_vendor"); err != nil || vendor == "Amazon EC2" {
+		return err == nil
+	}
+	biosVersion, err := readDMI(dmiPath, "bios_version")
+	return err == nil && strings.Contains(strings.ToLower(biosVersion), "amazon")
+}
+
+func readDMI(dmiPath, name string) (string, error) {
+	value, err := os.ReadFile(filepath.Join(dmiPath, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// instanceIDFromDMI returns the instance ID that Nitro instances expose as their board
+// asset tag.
+func instanceIDFromDMI(dmiPath string) (string, error) {
+	assetTag, err := readDMI(dmiPath, "board_asset_tag")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(assetTag, "i-") {
+		return "", fmt.Errorf("board asset tag %q is not an instance ID", assetTag)
+	}
+	return assetTag, nil
+}
@@ -0,0 +1,92 @@
+package ec2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const defaultSysBlockPath = "/sys/block"
+
+// VolumeDevices returns the local device names of the attached EBS volumes, mapped to
+// volume IDs, as found by the last call to Detect.
+func (d *Detector) VolumeDevices() map[string]string {
+	d.volumeDevicesLock.Lock()
+	defer d.volumeDevicesLock.Unlock()
+	return d.volumeDevices
+}
+
+// clearVolumeDevices drops the mapping found by an earlier call to Detect when the
+// volumes could not be listed again, as devices may have been detached or renamed since.
+func (d *Detector) clearVolumeDevices() {
+	if d.resolveEBSDevices {
+		d.setVolumeDevices(nil)
+	}
+}
+
+func (d *Detector) setVolumeDevices(devices map[string]string) {
+	d.volumeDevicesLock.Lock()
+	defer d.volumeDevicesLock.Unlock()
+	d.volumeDevices = devices
+}
+
+// mapVolumeDevices maps device names, without the /dev/ prefix, to the IDs of the given
+// volumes attached to the instance. The attachment device name (e.g. /dev/sdf) is mapped
+// along with its Xen alias (xvdf). NVMe devices are resolved through their serial number
+// under sysBlockPath, which is the volume ID without its dash.
+func mapVolumeDevices(volumes []*ec2.Volume, instanceID string, sysBlockPath string) map[string]string {
+	devices := make(map[string]string)
+	volumeIDs := make(map[string]bool)
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) != "" && aws.StringValue(attachment.InstanceId) != instanceID {
+				continue
+			}
+			volumeID := aws.StringValue(attachment.VolumeId)
+			volumeIDs[volumeID] = true
+
+			device := strings.TrimPrefix(aws.StringValue(attachment.Device), "/dev/")
+			if device == "" {
+				continue
+			}
+			devices[device] = volumeID
+			switch {
+			case strings.HasPrefix(device, "sd"):
+				devices["xvd"+strings.TrimPrefix(device, "sd")] = volumeID
+			case strings.HasPrefix(device, "xvd"):
+				devices["sd"+strings.TrimPrefix(device, "xvd")] = volumeID
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(sysBlockPath)
+	if err != nil {
+		return devices
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "nvme") {
+			continue
+		}
+		serial, err := os.ReadFile(filepath.Join(sysBlockPath, entry.Name(), "device", "serial"))
+		if err != nil {
+			continue
+		}
+		volumeID := nvmeSerialToVolumeID(strings.TrimSpace(string(serial)))
+		if volumeIDs[volumeID] {
+			devices[entry.Name()] = volumeID
+		}
+	}
+	return devices
+}
+
+// nvmeSerialToVolumeID converts an EBS NVMe serial such as vol0123456789abcdef0 to the
+// volume ID vol-0123456789abcdef0.
+func nvmeSerialToVolumeID(serial string) string {
+	if !strings.HasPrefix(serial, "vol") || strings.HasPrefix(serial, "vol-") {
+		return serial
+	}
+	return "vol-" + strings.TrimPrefix(serial, "vol")
+}
@@ -2,16 +2,22 @@ package ec2
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	conventions "go.opentelemetry.io/collector/semconv/v1.6.1"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
 	ec2provider "poc/internal/ec2metadata"
@@ -26,52 +32,266 @@ const (
 )
 
 var _ internal.Detector = (*Detector)(nil)
+var _ internal.InstanceIdentifier = (*Detector)(nil)
+var _ internal.VolumeDeviceMapper = (*Detector)(nil)
+var _ io.Closer = (*Detector)(nil)
 
 type Detector struct {
 	metadataProvider ec2provider.Provider
+	fetchOptions     fetchOptions
+	apiSettings      apiSettings
+	apiJitter        time.Duration
+	apiJitterOnce    sync.Once
+	releaseLimiter   func()
+	tagSource        TagSource
+	network          NetworkConfig
+	keyStyle         KeyStyle
+	mergePolicy      internal.MergePolicy
 	logger           *zap.Logger
+
+	dmiPath           string
+	resolveEBSDevices bool
+	sysBlockPath      string
+	volumeDevicesLock sync.Mutex
+	volumeDevices     map[string]string
+
+	// api is the EC2 API client, built on first use for apiRegion and apiHTTPClient.
+	apiLock       sync.Mutex
+	api           *ec2.EC2
+	apiRegion     string
+	apiHTTPClient *http.Client
 }
 
-func NewDetector(set component.ProcessorCreateSettings) (internal.Detector, error) {
+func NewDetector(set component.ProcessorCreateSettings, dcfg internal.DetectorConfig) (internal.Detector, error) {
+	cfg, ok := dcfg.(Config)
+	if !ok {
+		return nil, fmt.Errorf("invalid config type %T for the %s detector", dcfg, TypeStr)
+	}
+
+	filter, err := newTagFilter(cfg.IncludeTags, cfg.ExcludeTags)
+	if err != nil {
+		return nil, err
+	}
+
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Detector{
-		metadataProvider: ec2provider.NewProvider(sess),
-		logger:           set.Logger,
+		metadataProvider: ec2provider.NewProvider(sess, cfg.IMDS.providerOptions(cfg.Endpoint)...),
+		fetchOptions: fetchOptions{
+			filter:      filter,
+			autoScaling: cfg.AutoScaling,
+			keyStyle:    cfg.KeyStyle,
+			maxResults:  cfg.MaxResults,
+			maxPages:    cfg.MaxPages,
+			limiter:     apiLimiter,
+			logger:      set.Logger,
+		},
+		releaseLimiter:    apiLimiter.acquire(cfg.APIRateLimit),
+		apiSettings:       newAPISettings(cfg),
+		apiJitter:         cfg.APIJitter,
+		tagSource:         cfg.TagSource,
+		network:           cfg.Network,
+		keyStyle:          cfg.KeyStyle,
+		mergePolicy:       cfg.MergePolicy,
+		logger:            set.Logger,
+		dmiPath:           defaultDMIPath,
+		resolveEBSDevices: cfg.ResolveEBSDevices,
+		sysBlockPath:      defaultSysBlockPath,
 	}, nil
 }
 
+// Close stops counting the detector's api_rate_limit towards the limit shared by the
+// detectors of the process.
+func (d *Detector) Close() error {
+	if d.releaseLimiter != nil {
+		d.releaseLimiter()
+	}
+	return nil
+}
+
+// waitAPIJitter delays the first EC2 API call of the detector by a random part of
+// api_jitter, so that a fleet started at the same time does not call the API at once.
+// The delay is cut to half the time left before the deadline of ctx, which leaves the
+// calls themselves the other half.
+func (d *Detector) waitAPIJitter(ctx context.Context) error {
+	var delay time.Duration
+	d.apiJitterOnce.Do(func() {
+		delay = randomDelay(d.apiJitter)
+	})
+	if deadline, ok := ctx.Deadline(); ok && delay > time.Until(deadline)/2 {
+		delay = time.Until(deadline) / 2
+	}
+	return sleep(ctx, delay)
+}
+
+// InstanceID returns the ID of the instance the detector runs on. While instance metadata
+// is unavailable it falls back to the ID found in the DMI data of Nitro instances, so
+// that a cached resource can still be matched to the instance.
+func (d *Detector) InstanceID(ctx context.Context) (string, error) {
+	instanceID, err := d.metadataProvider.InstanceID(ctx)
+	if err == nil {
+		return instanceID, nil
+	}
+	if instanceID, dmiErr := instanceIDFromDMI(d.dmiPath); dmiErr == nil {
+		d.logger.Debug("EC2 metadata unavailable, using the instance ID from DMI", zap.Error(err))
+		return instanceID, nil
+	}
+	return "", err
+}
+
+// Detect reads the instance identity, tags and attached volumes. Each of them may fail
+// on its own, e.g. when the instance role lacks ec2:DescribeVolumes; in that case the
+// attributes that could be read are returned with an *internal.PartialResultError.
 func (d *Detector) Detect(ctx context.Context) (resource pcommon.Resource, schemaURL string, err error) {
 	res := pcommon.NewResource()
-	if _, err = d.metadataProvider.InstanceID(ctx); err != nil {
-		d.logger.Debug("EC2 metadata unavailable", zap.Error(err))
-		return res, "", nil
+	instanceID, err := d.metadataProvider.InstanceID(ctx)
+	if err != nil {
+		d.clearVolumeDevices()
+		// Off EC2 the metadata service cannot be reached at all, so there is nothing to
+		// detect. The failure is only returned, to be retried, when the metadata service
+		// answered with an HTTP error or the host's DMI data says it is an EC2 instance.
+		var rerr awserr.RequestFailure
+		if !isEC2Host(d.dmiPath) && !errors.As(err, &rerr) {
+			d.logger.Debug("EC2 metadata unavailable, not running on EC2", zap.Error(err))
+			return res, "", nil
+		}
+		return res, "", fmt.Errorf("failed to read the instance ID from instance metadata: %w", err)
 	}
 
+	attr := newAttributeWriter(res.Attributes(), d.mergePolicy)
+	var failures []internal.SourceError
+
 	meta, err := d.metadataProvider.Get(ctx)
 	if err != nil {
-		return res, "", fmt.Errorf("failed getting identity document: %w", err)
+		// Without the identity document the region is unknown, so only the instance ID
+		// can be reported and the EC2 API cannot be queried. The volumes cannot be
+		// listed either, so a mapping from an earlier run is not trusted.
+		failures = append(failures, newSourceError(sourceIdentityDocument, err))
+		d.clearVolumeDevices()
+		if d.keyStyle.cloudWatchKeys() {
+			attr.put(sourceIdentityDocument, MetadataKeyInstanceId, instanceID)
+		}
+		if d.keyStyle.semconvKeys() {
+			attr.put(sourceIdentityDocument, conventions.AttributeHostID, instanceID)
+		}
+		return res, d.schemaURL(), &internal.PartialResultError{Failures: append(failures, attr.failures()...)}
 	}
 
-	attr := res.Attributes()
-	attr.InsertString(MetadataKeyInstanceId, meta.InstanceID)
-	attr.InsertString(MetadataKeyImageId, meta.ImageID)
-	attr.InsertString(MetadataKeyInstaceType, meta.InstanceType)
+	if d.keyStyle.cloudWatchKeys() {
+		attr.put(sourceIdentityDocument, MetadataKeyInstanceId, meta.InstanceID)
+		attr.put(sourceIdentityDocument, MetadataKeyImageId, meta.ImageID)
+		attr.put(sourceIdentityDocument, MetadataKeyInstaceType, meta.InstanceType)
+	}
+	if d.keyStyle.semconvKeys() {
+		attr.put(sourceIdentityDocument, conventions.AttributeCloudProvider, conventions.AttributeCloudProviderAWS)
+		attr.put(sourceIdentityDocument, conventions.AttributeCloudPlatform, conventions.AttributeCloudPlatformAWSEC2)
+		attr.put(sourceIdentityDocument, conventions.AttributeCloudRegion, meta.Region)
+		attr.put(sourceIdentityDocument, conventions.AttributeCloudAvailabilityZone, meta.AvailabilityZone)
+		attr.put(sourceIdentityDocument, conventions.AttributeCloudAccountID, meta.AccountID)
+		attr.put(sourceIdentityDocument, conventions.AttributeHostID, meta.InstanceID)
+		attr.put(sourceIdentityDocument, conventions.AttributeHostType, meta.InstanceType)
+		attr.put(sourceIdentityDocument, conventions.AttributeHostImageID, meta.ImageID)
 
-	client := getHTTPClientSettings(ctx, d.logger)
-	tagsAndVolumes, err := connectAndFetchEc2TagsandEcsVolume(meta.Region, meta.InstanceID, client)
+		if hostname, err := d.metadataProvider.Hostname(ctx); err != nil {
+			failures = append(failures, newSourceError(sourceHostname, err))
+		} else {
+			attr.put(sourceHostname, conventions.AttributeHostName, hostname)
+		}
+	}
 
-	if err != nil {
-		return res, "", fmt.Errorf("failed fetching ec2 instance tags: %w", err)
+	networkAttrs, err := detectNetwork(ctx, d.metadataProvider, d.network, meta.AvailabilityZone)
+	// Each failed path is classified on its own, so that one permanent failure does not
+	// stop the others from being retried.
+	for _, err := range multierr.Errors(err) {
+		failures = append(failures, newSourceError(sourceNetwork, err))
+	}
+	insertNetworkAttributes(attr, networkAttrs, d.keyStyle)
+
+	if d.fetchOptions.autoScaling {
+		// The state is read on every detection, so that it follows the instance through
+		// its lifecycle as the resource is refreshed.
+		state, err := detectLifecycleState(ctx, d.metadataProvider)
+		switch {
+		case err != nil:
+			failures = append(failures, newSourceError(sourceLifecycleState, err))
+		case state != "":
+			if d.keyStyle.cloudWatchKeys() {
+				attr.put(sourceLifecycleState, MetadataKeyLifecycleState, state)
+			}
+			if d.keyStyle.semconvKeys() {
+				attr.put(sourceLifecycleState, attributeAWSAutoScalingLifecycleState, state)
+			}
+		}
+	}
+
+	opts := d.fetchOptions
+	if d.tagSource == TagSourceIMDS || d.tagSource == TagSourceIMDSThenAPI {
+		tags, err := d.metadataProvider.Tags(ctx)
+		switch {
+		case err == nil:
+			autoScalingAttrs := make(map[string]string)
+			for key, val := range tags {
+				if opts.autoScaling {
+					addAutoScalingTag(autoScalingAttrs, key, val, opts.keyStyle)
+				}
+				if opts.filter.keep(key) {
+					attr.put(sourceIMDSTags, key, val)
+				}
+			}
+			for key, val := range autoScalingAttrs {
+				attr.put(sourceIMDSTags, key, val)
+			}
+			opts.skipTags = true
+		case d.tagSource == TagSourceIMDS:
+			failures = append(failures, newSourceError(sourceIMDSTags, err))
+			opts.skipTags = true
+		default:
+			d.logger.Debug("Instance tags unavailable from metadata, reading them from the EC2 API", zap.Error(err))
+		}
+	}
+
+	client := getHTTPClientSettings(ctx, d.logger)
+	tagsAndVolumes, volumes, err := d.connectAndFetchEc2TagsandEcsVolume(ctx, meta.Region, meta.InstanceID, client, opts)
+	var partial *internal.PartialResultError
+	switch {
+	case err == nil:
+	case errors.As(err, &partial):
+		failures = append(failures, partial.Failures...)
+	default:
+		failures = append(failures, apiSourceErrors(opts, err)...)
 	}
 	for key, val := range tagsAndVolumes {
-		attr.InsertString(key, val)
+		attr.put(sourceTags, key, val)
+	}
+	if d.resolveEBSDevices {
+		d.setVolumeDevices(mapVolumeDevices(volumes, meta.InstanceID, d.sysBlockPath))
+	}
+
+	failures = append(failures, attr.failures()...)
+	if len(failures) > 0 {
+		return res, d.schemaURL(), &internal.PartialResultError{Failures: failures}
 	}
+	return res, d.schemaURL(), nil
+}
+
+// apiSourceErrors reports err for every source read from the EC2 API.
+func apiSourceErrors(opts fetchOptions, err error) []internal.SourceError {
+	if opts.skipTags {
+		return []internal.SourceError{newSourceError(sourceVolumes, err)}
+	}
+	return []internal.SourceError{newSourceError(sourceTags, err), newSourceError(sourceVolumes, err)}
+}
 
-	return res, conventions.SchemaURL, nil
+// schemaURL returns the schema URL of the emitted keys. Only the semantic convention
+// keys follow the schema.
+func (d *Detector) schemaURL() string {
+	if !d.keyStyle.semconvKeys() {
+		return ""
+	}
+	return conventions.SchemaURL
 }
 
 func getHTTPClientSettings(ctx context.Context, logger *zap.Logger) *http.Client {
@@ -83,52 +303,140 @@ func getHTTPClientSettings(ctx context.Context, logger *zap.Logger) *http.Client
 	return client
 }
 
-func connectAndFetchEc2TagsandEcsVolume(region string, instanceID string, client *http.Client) (map[string]string, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:     aws.String(region),
-		HTTPClient: client},
-	)
+// fetchOptions controls which tags are kept and how the EC2 API results are paged.
+type fetchOptions struct {
+	filter *tagFilter
+	// autoScaling adds the Auto Scaling and launch template tags as normalized attributes
+	// with keys of keyStyle.
+	autoScaling bool
+	keyStyle    KeyStyle
+	maxResults  int64
+	maxPages    int
+	skipTags    bool
+	limiter     *adaptiveLimiter
+	logger      *zap.Logger
+}
+
+func (d *Detector) connectAndFetchEc2TagsandEcsVolume(ctx context.Context, region string, instanceID string, client *http.Client, opts fetchOptions) (map[string]string, []*ec2.Volume, error) {
+	e, err := d.apiClient(region, client)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if err = d.waitAPIJitter(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return fetchEC2TagsAndVolumes(ctx, e, instanceID, opts)
+}
+
+// fetchEC2TagsAndVolumes reads the instance tags and attached volumes and returns them as
+// attributes, along with the volumes themselves. If only one of them fails, the other is
+// still returned along with an *internal.PartialResultError.
+func fetchEC2TagsAndVolumes(ctx context.Context, svc ec2iface.EC2API, instanceID string, opts fetchOptions) (map[string]string, []*ec2.Volume, error) {
+	tagsAndVolumes := make(map[string]string)
+	var failures []internal.SourceError
+
+	var tags []*ec2.TagDescription
+	if !opts.skipTags {
+		var err error
+		if tags, err = describeTags(ctx, svc, instanceID, opts); err != nil {
+			failures = append(failures, newSourceError(sourceTags, err))
+		}
+	}
+	for _, tag := range tags {
+		if opts.autoScaling {
+			addAutoScalingTag(tagsAndVolumes, *tag.Key, *tag.Value, opts.keyStyle)
+		}
+		if !opts.filter.keep(*tag.Key) {
+			continue
+		}
+		tagsAndVolumes[*tag.Key] = *tag.Value
+	}
+
+	volumes, err := describeVolumes(ctx, svc, instanceID, opts)
+	if err != nil {
+		failures = append(failures, newSourceError(sourceVolumes, err))
+	}
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			tagsAndVolumes[*attachment.VolumeId] = fmt.Sprintf("aws://%s/%s", *volume.AvailabilityZone, *attachment.VolumeId)
+		}
 	}
-	e := ec2.New(sess)
 
-	return fetchEC2TagsAndVolumes(e, instanceID)
+	if len(failures) > 0 {
+		return tagsAndVolumes, volumes, &internal.PartialResultError{Failures: failures}
+	}
+	return tagsAndVolumes, volumes, nil
 }
 
-func fetchEC2TagsAndVolumes(svc ec2iface.EC2API, instanceID string) (map[string]string, error) {
-	ec2Tags, err := svc.DescribeTags(&ec2.DescribeTagsInput{
+// describeTags returns every tag of the instance, following NextToken for at most
+// opts.maxPages pages.
+func describeTags(ctx context.Context, svc ec2iface.EC2API, instanceID string, opts fetchOptions) ([]*ec2.TagDescription, error) {
+	input := &ec2.DescribeTagsInput{
 		Filters: []*ec2.Filter{{
 			Name:   aws.String("resource-id"),
 			Values: aws.StringSlice([]string{instanceID}),
 		}},
-	})
-	if err != nil {
-		return nil, err
 	}
-	tagsAndVolumes := make(map[string]string)
-	for _, tag := range ec2Tags.Tags {
-		tagsAndVolumes[*tag.Key] = *tag.Value
+	if opts.maxResults > 0 {
+		input.MaxResults = aws.Int64(opts.maxResults)
 	}
 
-	ec2Volumes, err := svc.DescribeVolumes(&ec2.DescribeVolumesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("attachment.instance-id"),
-				Values: aws.StringSlice([]string{instanceID}),
-			},
-		},
-	})
+	var tags []*ec2.TagDescription
+	for page := 1; ; page++ {
+		var output *ec2.DescribeTagsOutput
+		err := callAPI(ctx, opts, func() (err error) {
+			output, err = svc.DescribeTagsWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, output.Tags...)
 
-	if err != nil {
-		return nil, err
+		if aws.StringValue(output.NextToken) == "" {
+			return tags, nil
+		}
+		if opts.maxPages > 0 && page >= opts.maxPages {
+			opts.logger.Warn("reached the page limit, instance tags are truncated", zap.Int("max_pages", opts.maxPages))
+			return tags, nil
+		}
+		input.NextToken = output.NextToken
 	}
+}
 
-	for _, volume := range ec2Volumes.Volumes {
-		for _, attachment := range volume.Attachments {
-			tagsAndVolumes[*attachment.VolumeId] = fmt.Sprintf("aws://%s/%s", *volume.AvailabilityZone, *attachment.VolumeId)
-		}
+// describeVolumes returns every volume attached to the instance, following NextToken
+// for at most opts.maxPages pages.
+func describeVolumes(ctx context.Context, svc ec2iface.EC2API, instanceID string, opts fetchOptions) ([]*ec2.Volume, error) {
+	input := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("attachment.instance-id"),
+			Values: aws.StringSlice([]string{instanceID}),
+		}},
+	}
+	if opts.maxResults > 0 {
+		input.MaxResults = aws.Int64(opts.maxResults)
 	}
 
-	return tagsAndVolumes, nil
+	var volumes []*ec2.Volume
+	for page := 1; ; page++ {
+		var output *ec2.DescribeVolumesOutput
+		err := callAPI(ctx, opts, func() (err error) {
+			output, err = svc.DescribeVolumesWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, output.Volumes...)
+
+		if aws.StringValue(output.NextToken) == "" {
+			return volumes, nil
+		}
+		if opts.maxPages > 0 && page >= opts.maxPages {
+			opts.logger.Warn("reached the page limit, attached volumes are truncated", zap.Int("max_pages", opts.maxPages))
+			return volumes, nil
+		}
+		input.NextToken = output.NextToken
+	}
 }
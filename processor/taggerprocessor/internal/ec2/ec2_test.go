@@ -0,0 +1,834 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	conventions "go.opentelemetry.io/collector/semconv/v1.6.1"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	ec2provider "poc/internal/ec2metadata"
+	"poc/processor/taggerprocessor/internal"
+)
+
+// fakeEC2 serves DescribeTags and DescribeVolumes from pre-built pages keyed by NextToken.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	tagPages    []*ec2.DescribeTagsOutput
+	volumePages []*ec2.DescribeVolumesOutput
+	volumeErr   error
+	// tagThrottles is the number of DescribeTags calls to throttle before answering.
+	tagThrottles int
+
+	tagCalls    int
+	volumeCalls int
+	maxResults  []int64
+}
+
+func pageIndex(token *string) int {
+	var i int
+	if token != nil {
+		fmt.Sscanf(*token, "page-%d", &i)
+	}
+	return i
+}
+
+func pageToken(i, pages int) *string {
+	if i+1 >= pages {
+		return nil
+	}
+	return aws.String(fmt.Sprintf("page-%d", i+1))
+}
+
+func (f *fakeEC2) DescribeTagsWithContext(_ aws.Context, input *ec2.DescribeTagsInput, _ ...request.Option) (*ec2.DescribeTagsOutput, error) {
+	f.tagCalls++
+	if f.tagThrottles > 0 {
+		f.tagThrottles--
+		return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	}
+	f.maxResults = append(f.maxResults, aws.Int64Value(input.MaxResults))
+	i := pageIndex(input.NextToken)
+	page := *f.tagPages[i]
+	page.NextToken = pageToken(i, len(f.tagPages))
+	return &page, nil
+}
+
+func (f *fakeEC2) DescribeVolumesWithContext(_ aws.Context, input *ec2.DescribeVolumesInput, _ ...request.Option) (*ec2.DescribeVolumesOutput, error) {
+	f.volumeCalls++
+	if f.volumeErr != nil {
+		return nil, f.volumeErr
+	}
+	i := pageIndex(input.NextToken)
+	page := *f.volumePages[i]
+	page.NextToken = pageToken(i, len(f.volumePages))
+	return &page, nil
+}
+
+func tagPage(keys ...string) *ec2.DescribeTagsOutput {
+	output := &ec2.DescribeTagsOutput{}
+	for _, key := range keys {
+		output.Tags = append(output.Tags, &ec2.TagDescription{Key: aws.String(key), Value: aws.String(key + "-value")})
+	}
+	return output
+}
+
+func volumePage(ids ...string) *ec2.DescribeVolumesOutput {
+	output := &ec2.DescribeVolumesOutput{}
+	for _, id := range ids {
+		output.Volumes = append(output.Volumes, &ec2.Volume{
+			AvailabilityZone: aws.String("us-west-2a"),
+			Attachments:      []*ec2.VolumeAttachment{{VolumeId: aws.String(id)}},
+		})
+	}
+	return output
+}
+
+func TestFetchEC2TagsAndVolumes_pagination(t *testing.T) {
+	svc := &fakeEC2{
+		tagPages:    []*ec2.DescribeTagsOutput{tagPage("Name", "Team"), tagPage("CostCenter"), tagPage("Owner")},
+		volumePages: []*ec2.DescribeVolumesOutput{volumePage("vol-1", "vol-2"), volumePage("vol-3")},
+	}
+
+	got, _, err := fetchEC2TagsAndVolumes(context.Background(), svc, "i-1", fetchOptions{maxResults: 5, logger: zap.NewNop()})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"Name":       "Name-value",
+		"Team":       "Team-value",
+		"CostCenter": "CostCenter-value",
+		"Owner":      "Owner-value",
+		"vol-1":      "aws://us-west-2a/vol-1",
+		"vol-2":      "aws://us-west-2a/vol-2",
+		"vol-3":      "aws://us-west-2a/vol-3",
+	}, got)
+	assert.Equal(t, 3, svc.tagCalls)
+	assert.Equal(t, 2, svc.volumeCalls)
+	assert.Equal(t, []int64{5, 5, 5}, svc.maxResults)
+}
+
+func TestFetchEC2TagsAndVolumes_maxPages(t *testing.T) {
+	svc := &fakeEC2{
+		tagPages:    []*ec2.DescribeTagsOutput{tagPage("Name"), tagPage("Team"), tagPage("Owner")},
+		volumePages: []*ec2.DescribeVolumesOutput{volumePage("vol-1"), volumePage("vol-2")},
+	}
+
+	got, _, err := fetchEC2TagsAndVolumes(context.Background(), svc, "i-1", fetchOptions{maxPages: 1, logger: zap.NewNop()})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"Name": "Name-value", "vol-1": "aws://us-west-2a/vol-1"}, got)
+	assert.Equal(t, 1, svc.tagCalls)
+	assert.Equal(t, 1, svc.volumeCalls)
+	assert.Equal(t, []int64{0}, svc.maxResults)
+}
+
+func TestFetchEC2TagsAndVolumes_partialResult(t *testing.T) {
+	svc := &fakeEC2{
+		tagPages:  []*ec2.DescribeTagsOutput{tagPage("Name")},
+		volumeErr: awserr.New("UnauthorizedOperation", "not authorized to perform ec2:DescribeVolumes", nil),
+	}
+
+	got, _, err := fetchEC2TagsAndVolumes(context.Background(), svc, "i-1", fetchOptions{logger: zap.NewNop()})
+	assert.Equal(t, map[string]string{"Name": "Name-value"}, got)
+
+	var partial *internal.PartialResultError
+	require.ErrorAs(t, err, &partial)
+	require.Len(t, partial.Failures, 1)
+	assert.Equal(t, sourceVolumes, partial.Failures[0].Source)
+	assert.True(t, partial.Failures[0].Permanent)
+	assert.False(t, partial.Retryable())
+}
+
+func TestMapVolumeDevices(t *testing.T) {
+	sysBlock := t.TempDir()
+	for device, serial := range map[string]string{"nvme0n1": "vol0aaaaaaaaaaaaaaaa", "nvme1n1": "vol0bbbbbbbbbbbbbbbb\n", "nvme2n1": "AWS1234"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysBlock, device, "device"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(sysBlock, device, "device", "serial"), []byte(serial), 0o600))
+	}
+
+	volumes := []*ec2.Volume{
+		{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1"), VolumeId: aws.String("vol-0aaaaaaaaaaaaaaaa"), Device: aws.String("/dev/xvda")}}},
+		{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1"), VolumeId: aws.String("vol-0bbbbbbbbbbbbbbbb"), Device: aws.String("/dev/sdf")}}},
+	}
+
+	assert.Equal(t, map[string]string{
+		"xvda":    "vol-0aaaaaaaaaaaaaaaa",
+		"sda":     "vol-0aaaaaaaaaaaaaaaa",
+		"sdf":     "vol-0bbbbbbbbbbbbbbbb",
+		"xvdf":    "vol-0bbbbbbbbbbbbbbbb",
+		"nvme0n1": "vol-0aaaaaaaaaaaaaaaa",
+		"nvme1n1": "vol-0bbbbbbbbbbbbbbbb",
+	}, mapVolumeDevices(volumes, "i-1", sysBlock))
+}
+
+// newFakeEC2API starts a local stand-in for the EC2 API that counts the calls of each
+// action.
+func newFakeEC2API(t *testing.T, calls map[string]int) *httptest.Server {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	srv := httptest.NewServer(fakeEC2APIHandler(t, calls))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fakeEC2APIHandler(t *testing.T, calls map[string]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		calls[r.Form.Get("Action")]++
+		switch r.Form.Get("Action") {
+		case "DescribeTags":
+			fmt.Fprint(w, `<DescribeTagsResponse><tagSet><item><resourceId>i-1</resourceId>`+
+				`<key>Name</key><value>web</value></item></tagSet></DescribeTagsResponse>`)
+		case "DescribeVolumes":
+			fmt.Fprint(w, `<DescribeVolumesResponse><volumeSet><item><volumeId>vol-1</volumeId>`+
+				`<availabilityZone>us-east-1a</availabilityZone><attachmentSet><item><volumeId>vol-1</volumeId>`+
+				`<instanceId>i-1</instanceId><device>/dev/xvda</device></item></attachmentSet></item></volumeSet>`+
+				`</DescribeVolumesResponse>`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+// newFakeIMDS starts a local stand-in for the instance metadata service serving the
+// given meta-data paths along with the identity document of instance i-1.
+func newFakeIMDS(t *testing.T, metadata map[string]string) *httptest.Server {
+	srv := httptest.NewServer(fakeIMDSHandler(metadata))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fakeIMDSHandler(metadata map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/latest/api/token":
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/latest/dynamic/instance-identity/document":
+			fmt.Fprint(w, `{"instanceId":"i-1","region":"us-east-1","availabilityZone":"us-east-1a",`+
+				`"imageId":"ami-1","instanceType":"t3.micro","accountId":"123456789012"}`)
+		case strings.HasPrefix(r.URL.Path, "/latest/meta-data/"):
+			value, ok := metadata[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, value)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// TestConnectAndFetch_endpoint runs the detector's EC2 client against a local stand-in
+// for the EC2 API configured through the endpoint setting.
+func TestConnectAndFetch_endpoint(t *testing.T) {
+	srv := newFakeEC2API(t, map[string]int{})
+
+	filter, err := newTagFilter([]string{"*"}, nil)
+	require.NoError(t, err)
+	d := &Detector{apiSettings: apiSettings{endpoint: srv.URL}}
+	got, volumes, err := d.connectAndFetchEc2TagsandEcsVolume(context.Background(), "us-east-1", "i-1", srv.Client(),
+		fetchOptions{filter: filter, logger: zap.NewNop()})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Name": "web", "vol-1": "aws://us-east-1a/vol-1"}, got)
+	assert.Len(t, volumes, 1)
+}
+
+func TestDetect_tagSource(t *testing.T) {
+	tests := []struct {
+		name         string
+		tagSource    TagSource
+		imdsTags     bool
+		want         map[string]interface{}
+		describeTags int
+		failures     []string
+	}{
+		{
+			name:      "imds",
+			tagSource: TagSourceIMDS,
+			imdsTags:  true,
+			want:      map[string]interface{}{"Name": "imds-web", "Team": "core"},
+		},
+		{
+			name:      "imds without tags in metadata",
+			tagSource: TagSourceIMDS,
+			want:      map[string]interface{}{},
+			failures:  []string{sourceIMDSTags},
+		},
+		{
+			name:         "imds then api",
+			tagSource:    TagSourceIMDSThenAPI,
+			want:         map[string]interface{}{"Name": "web"},
+			describeTags: 1,
+		},
+		{
+			name:         "api",
+			tagSource:    TagSourceAPI,
+			imdsTags:     true,
+			want:         map[string]interface{}{"Name": "web"},
+			describeTags: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := map[string]string{"instance-id": "i-1"}
+			if tt.imdsTags {
+				metadata["tags/instance"] = "Name\nTeam"
+				metadata["tags/instance/Name"] = "imds-web"
+				metadata["tags/instance/Team"] = "core"
+			}
+			imds := newFakeIMDS(t, metadata)
+			calls := map[string]int{}
+			api := newFakeEC2API(t, calls)
+
+			filter, err := newTagFilter([]string{"*"}, nil)
+			require.NoError(t, err)
+			d := &Detector{
+				metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL)),
+				fetchOptions:     fetchOptions{filter: filter, logger: zap.NewNop()},
+				apiSettings:      apiSettings{endpoint: api.URL},
+				tagSource:        tt.tagSource,
+				keyStyle:         KeyStyleCloudWatch,
+				logger:           zap.NewNop(),
+			}
+
+			res, _, err := d.Detect(context.Background())
+			var sources []string
+			var partial *internal.PartialResultError
+			if errors.As(err, &partial) {
+				for _, failure := range partial.Failures {
+					sources = append(sources, failure.Source)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.failures, sources)
+
+			got := res.Attributes().AsRaw()
+			for _, key := range []string{MetadataKeyInstanceId, MetadataKeyImageId, MetadataKeyInstaceType, "vol-1"} {
+				assert.Contains(t, got, key)
+				delete(got, key)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.describeTags, calls["DescribeTags"])
+			assert.Equal(t, 1, calls["DescribeVolumes"])
+		})
+	}
+}
+
+func TestDetect_keyStyle(t *testing.T) {
+	cloudWatch := map[string]interface{}{
+		MetadataKeyInstanceId:  "i-1",
+		MetadataKeyImageId:     "ami-1",
+		MetadataKeyInstaceType: "t3.micro",
+	}
+	semconv := map[string]interface{}{
+		conventions.AttributeCloudProvider:         conventions.AttributeCloudProviderAWS,
+		conventions.AttributeCloudPlatform:         conventions.AttributeCloudPlatformAWSEC2,
+		conventions.AttributeCloudRegion:           "us-east-1",
+		conventions.AttributeCloudAvailabilityZone: "us-east-1a",
+		conventions.AttributeCloudAccountID:        "123456789012",
+		conventions.AttributeHostID:                "i-1",
+		conventions.AttributeHostType:              "t3.micro",
+		conventions.AttributeHostImageID:           "ami-1",
+		conventions.AttributeHostName:              "ip-10-0-0-5.ec2.internal",
+	}
+	both := make(map[string]interface{})
+	for k, v := range cloudWatch {
+		both[k] = v
+	}
+	for k, v := range semconv {
+		both[k] = v
+	}
+
+	tests := []struct {
+		keyStyle  KeyStyle
+		want      map[string]interface{}
+		schemaURL string
+	}{
+		{keyStyle: KeyStyleCloudWatch, want: cloudWatch},
+		{keyStyle: KeyStyleSemconv, want: semconv, schemaURL: conventions.SchemaURL},
+		{keyStyle: KeyStyleBoth, want: both, schemaURL: conventions.SchemaURL},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyStyle), func(t *testing.T) {
+			imds := newFakeIMDS(t, map[string]string{"instance-id": "i-1", "hostname": "ip-10-0-0-5.ec2.internal"})
+			api := newFakeEC2API(t, map[string]int{})
+
+			filter, err := newTagFilter([]string{"*"}, nil)
+			require.NoError(t, err)
+			d := &Detector{
+				metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL)),
+				fetchOptions:     fetchOptions{filter: filter, logger: zap.NewNop()},
+				apiSettings:      apiSettings{endpoint: api.URL},
+				tagSource:        TagSourceAPI,
+				keyStyle:         tt.keyStyle,
+				logger:           zap.NewNop(),
+			}
+
+			res, schemaURL, err := d.Detect(context.Background())
+			require.NoError(t, err)
+			got := res.Attributes().AsRaw()
+			// Tags and volumes are added under their own keys whatever the key style.
+			assert.Equal(t, "web", got["Name"])
+			assert.Equal(t, "aws://us-east-1a/vol-1", got["vol-1"])
+			delete(got, "Name")
+			delete(got, "vol-1")
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.schemaURL, schemaURL)
+		})
+	}
+}
+
+func TestDetect_mergePolicy(t *testing.T) {
+	tests := []struct {
+		policy   internal.MergePolicy
+		want     string
+		failures []internal.SourceError
+	}{
+		{policy: internal.MergePolicyOverride, want: "i-tagged"},
+		{policy: internal.MergePolicyPreserve, want: "i-1"},
+		{
+			policy: internal.MergePolicyFailOnConflict,
+			want:   "i-1",
+			failures: []internal.SourceError{{
+				Source:    sourceIMDSTags,
+				Err:       &internal.ConflictError{Keys: []string{MetadataKeyInstanceId}},
+				Permanent: true,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// The tag conflicts with the instance ID from the identity document.
+			imds := newFakeIMDS(t, map[string]string{
+				"instance-id":              "i-1",
+				"tags/instance":            "InstanceId",
+				"tags/instance/InstanceId": "i-tagged",
+			})
+			api := newFakeEC2API(t, map[string]int{})
+
+			filter, err := newTagFilter([]string{"*"}, nil)
+			require.NoError(t, err)
+			d := &Detector{
+				metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL)),
+				fetchOptions:     fetchOptions{filter: filter, logger: zap.NewNop()},
+				apiSettings:      apiSettings{endpoint: api.URL},
+				tagSource:        TagSourceIMDS,
+				keyStyle:         KeyStyleCloudWatch,
+				mergePolicy:      tt.policy,
+				logger:           zap.NewNop(),
+			}
+
+			res, _, err := d.Detect(context.Background())
+			if tt.failures == nil {
+				require.NoError(t, err)
+			} else {
+				var partial *internal.PartialResultError
+				require.ErrorAs(t, err, &partial)
+				assert.Equal(t, tt.failures, partial.Failures)
+				assert.False(t, partial.Retryable())
+			}
+			got, _ := res.Attributes().Get(MetadataKeyInstanceId)
+			assert.Equal(t, tt.want, got.StringVal())
+		})
+	}
+}
+
+func TestFetchEC2TagsAndVolumes_throttled(t *testing.T) {
+	svc := &fakeEC2{
+		tagPages:     []*ec2.DescribeTagsOutput{tagPage("Name")},
+		volumePages:  []*ec2.DescribeVolumesOutput{volumePage("vol-1")},
+		tagThrottles: 1,
+	}
+	withoutBackOff(t)
+
+	got, _, err := fetchEC2TagsAndVolumes(context.Background(), svc, "i-1",
+		fetchOptions{limiter: newAdaptiveLimiter(100), logger: zap.NewNop()})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Name": "Name-value", "vol-1": "aws://us-west-2a/vol-1"}, got)
+	assert.Equal(t, 2, svc.tagCalls)
+}
+
+func TestDetectNetwork(t *testing.T) {
+	metadata := map[string]string{
+		"placement/availability-zone-id": "use1-az4",
+		"mac":                            "0e:49:61:0f:c3:11",
+		"network/interfaces/macs/0e:49:61:0f:c3:11/vpc-id":      "vpc-1",
+		"network/interfaces/macs/0e:49:61:0f:c3:11/subnet-id":   "subnet-1",
+		"network/interfaces/macs/0e:49:61:0f:c3:11/local-ipv4s": "10.0.0.5\n10.0.0.6",
+	}
+	provider := ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(newFakeIMDS(t, metadata).URL))
+
+	tests := []struct {
+		name string
+		cfg  NetworkConfig
+		want map[string]interface{}
+	}{
+		{
+			name: "disabled",
+			want: map[string]interface{}{},
+		},
+		{
+			name: "availability zone and vpc",
+			cfg:  NetworkConfig{AvailabilityZone: true, VPC: true},
+			want: map[string]interface{}{
+				MetadataKeyAvailabilityZone:   "us-east-1a",
+				MetadataKeyAvailabilityZoneId: "use1-az4",
+				MetadataKeyVpcId:              "vpc-1",
+				MetadataKeySubnetId:           "subnet-1",
+			},
+		},
+		{
+			name: "addresses without a public IP",
+			cfg:  NetworkConfig{PrivateIP: true, MAC: true, PublicIP: true},
+			want: map[string]interface{}{
+				MetadataKeyPrivateIp:  "10.0.0.5",
+				MetadataKeyMacAddress: "0e:49:61:0f:c3:11",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := detectNetwork(context.Background(), provider, tt.cfg, "us-east-1a")
+			require.NoError(t, err)
+			res := pcommon.NewResource()
+			insertNetworkAttributes(newAttributeWriter(res.Attributes(), internal.MergePolicyOverride), attrs, KeyStyleCloudWatch)
+			assert.Equal(t, tt.want, res.Attributes().AsRaw())
+		})
+	}
+}
+
+// TestDetectNetwork_partialFailure checks that a failed path does not stop the others
+// from being read.
+func TestDetectNetwork_partialFailure(t *testing.T) {
+	cfg := NetworkConfig{AvailabilityZone: true, VPC: true, PrivateIP: true}
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     map[string]interface{}
+		failed   []string
+	}{
+		{
+			name: "zone ID and subnet ID",
+			metadata: map[string]string{
+				"mac": "0e:49:61:0f:c3:11",
+				"network/interfaces/macs/0e:49:61:0f:c3:11/vpc-id":      "vpc-1",
+				"network/interfaces/macs/0e:49:61:0f:c3:11/local-ipv4s": "10.0.0.5",
+			},
+			want: map[string]interface{}{
+				MetadataKeyAvailabilityZone: "us-east-1a",
+				MetadataKeyVpcId:            "vpc-1",
+				MetadataKeyPrivateIp:        "10.0.0.5",
+			},
+			failed: []string{"placement/availability-zone-id", "network/interfaces/macs/0e:49:61:0f:c3:11/subnet-id"},
+		},
+		{
+			name:     "MAC address",
+			metadata: map[string]string{"placement/availability-zone-id": "use1-az4"},
+			want: map[string]interface{}{
+				MetadataKeyAvailabilityZone:   "us-east-1a",
+				MetadataKeyAvailabilityZoneId: "use1-az4",
+			},
+			failed: []string{"mac"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(newFakeIMDS(t, tt.metadata).URL))
+			attrs, err := detectNetwork(context.Background(), provider, cfg, "us-east-1a")
+			errs := multierr.Errors(err)
+			require.Len(t, errs, len(tt.failed))
+			for i, path := range tt.failed {
+				assert.True(t, strings.HasPrefix(errs[i].Error(), path+": "), errs[i].Error())
+				assert.True(t, isNotFound(errs[i]))
+			}
+			res := pcommon.NewResource()
+			insertNetworkAttributes(newAttributeWriter(res.Attributes(), internal.MergePolicyOverride), attrs, KeyStyleCloudWatch)
+			assert.Equal(t, tt.want, res.Attributes().AsRaw())
+		})
+	}
+}
+
+func TestDetect_autoScaling(t *testing.T) {
+	metadata := map[string]string{
+		"instance-id":        "i-1",
+		"tags/instance":      "Name\naws:autoscaling:groupName\naws:ec2launchtemplate:id\naws:ec2launchtemplate:version",
+		"tags/instance/Name": "web",
+		"tags/instance/aws:autoscaling:groupName":     "web-asg",
+		"tags/instance/aws:ec2launchtemplate:id":      "lt-0123",
+		"tags/instance/aws:ec2launchtemplate:version": "7",
+		"autoscaling/target-lifecycle-state":          "InService",
+	}
+	imds := newFakeIMDS(t, metadata)
+	api := newFakeEC2API(t, map[string]int{})
+
+	filter, err := newTagFilter([]string{"*"}, []string{"aws:*"})
+	require.NoError(t, err)
+	d := &Detector{
+		metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL)),
+		fetchOptions:     fetchOptions{filter: filter, autoScaling: true, keyStyle: KeyStyleCloudWatch, logger: zap.NewNop()},
+		apiSettings:      apiSettings{endpoint: api.URL},
+		tagSource:        TagSourceIMDS,
+		keyStyle:         KeyStyleCloudWatch,
+		logger:           zap.NewNop(),
+	}
+
+	detect := func() map[string]interface{} {
+		res, _, err := d.Detect(context.Background())
+		require.NoError(t, err)
+		got := res.Attributes().AsRaw()
+		for _, key := range []string{MetadataKeyInstanceId, MetadataKeyImageId, MetadataKeyInstaceType, "vol-1"} {
+			delete(got, key)
+		}
+		return got
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"Name":                           "web",
+		MetadataKeyAutoScalingGroupName:  "web-asg",
+		MetadataKeyLaunchTemplateId:      "lt-0123",
+		MetadataKeyLaunchTemplateVersion: "7",
+		MetadataKeyLifecycleState:        "InService",
+	}, detect())
+
+	metadata["autoscaling/target-lifecycle-state"] = "Warmed:Terminated"
+	assert.Equal(t, "Warmed:Terminated", detect()[MetadataKeyLifecycleState])
+
+	delete(metadata, "autoscaling/target-lifecycle-state")
+	assert.NotContains(t, detect(), MetadataKeyLifecycleState)
+}
+
+// writeDMI writes the given DMI entries to a temporary directory and returns its path.
+func writeDMI(t *testing.T, entries map[string]string) string {
+	dir := t.TempDir()
+	for name, value := range entries {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o600))
+	}
+	return dir
+}
+
+func TestIsEC2Host(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		ec2Host bool
+	}{
+		{name: "nitro", entries: map[string]string{"sys_vendor": "Amazon EC2"}, ec2Host: true},
+		{name: "xen", entries: map[string]string{"sys_vendor": "Xen", "bios_version": "4.11.amazon"}, ec2Host: true},
+		{name: "other", entries: map[string]string{"sys_vendor": "QEMU", "bios_version": "1.16.0"}},
+		{name: "unreadable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ec2Host, isEC2Host(writeDMI(t, tt.entries)))
+		})
+	}
+}
+
+func TestDetect_metadataUnreachable(t *testing.T) {
+	// Nothing listens on the metadata endpoint.
+	imds := httptest.NewServer(http.NotFoundHandler())
+	imds.Close()
+
+	tests := []struct {
+		name    string
+		entries map[string]string
+		wantErr bool
+	}{
+		{name: "not on EC2", entries: map[string]string{"sys_vendor": "QEMU", "bios_version": "1.16.0"}},
+		{name: "on EC2", entries: map[string]string{"sys_vendor": "Amazon EC2"}, wantErr: true},
+		// Without DMI data, e.g. on Windows or in a container without /sys, the host is
+		// taken not to be on EC2, so that detection is not retried forever.
+		{name: "unknown host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Detector{
+				metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL), ec2provider.WithMaxRetries(0)),
+				dmiPath:          writeDMI(t, tt.entries),
+				logger:           zap.NewNop(),
+			}
+			res, _, err := d.Detect(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, 0, res.Attributes().Len())
+		})
+	}
+}
+
+// TestDetect_retriesUntilMetadataRecovers checks that a metadata service answering 500
+// fails detection, so that the provider retries it, rather than yielding an empty resource.
+func TestDetect_retriesUntilMetadataRecovers(t *testing.T) {
+	var failures atomic.Int32
+	failures.Store(2)
+	fake := fakeIMDSHandler(map[string]string{"instance-id": "i-1"})
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/meta-data/instance-id" && failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake(w, r)
+	}))
+	t.Cleanup(imds.Close)
+	api := newFakeEC2API(t, map[string]int{})
+
+	filter, err := newTagFilter([]string{"*"}, nil)
+	require.NoError(t, err)
+	d := &Detector{
+		metadataProvider: ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL), ec2provider.WithMaxRetries(0)),
+		fetchOptions:     fetchOptions{filter: filter, logger: zap.NewNop()},
+		apiSettings:      apiSettings{endpoint: api.URL},
+		keyStyle:         KeyStyleCloudWatch,
+		dmiPath:          writeDMI(t, nil),
+		logger:           zap.NewNop(),
+	}
+	provider := internal.NewResourceProvider(zap.NewNop(), internal.ProviderSettings{
+		Timeout: 5 * time.Second,
+		Retry:   internal.RetrySettings{Enabled: true, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond},
+	}, d)
+	t.Cleanup(func() { assert.NoError(t, provider.Shutdown(context.Background())) })
+
+	res, _, err := provider.Get(context.Background(), api.Client())
+	assert.Error(t, err)
+	assert.Equal(t, 0, res.Attributes().Len())
+
+	assert.Eventually(t, func() bool {
+		res, _ := provider.Current()
+		id, ok := res.Attributes().Get(MetadataKeyInstanceId)
+		return ok && id.StringVal() == "i-1"
+	}, 5*time.Second, time.Millisecond)
+	assert.LessOrEqual(t, failures.Load(), int32(0))
+}
+
+// TestDetect_servesCacheWhileMetadataIsDown checks that a resource cached by a previous
+// run, including its volume devices, is used while instance metadata answers 500.
+func TestDetect_servesCacheWhileMetadataIsDown(t *testing.T) {
+	api := newFakeEC2API(t, map[string]int{})
+	filter, err := newTagFilter([]string{"*"}, nil)
+	require.NoError(t, err)
+	dmiPath := writeDMI(t, map[string]string{"sys_vendor": "Amazon EC2", "board_asset_tag": "i-1"})
+	newDetector := func(imdsURL string) *Detector {
+		return &Detector{
+			metadataProvider:  ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imdsURL), ec2provider.WithMaxRetries(0)),
+			fetchOptions:      fetchOptions{filter: filter, logger: zap.NewNop()},
+			apiSettings:       apiSettings{endpoint: api.URL},
+			keyStyle:          KeyStyleCloudWatch,
+			dmiPath:           dmiPath,
+			resolveEBSDevices: true,
+			sysBlockPath:      t.TempDir(),
+			logger:            zap.NewNop(),
+		}
+	}
+	settings := internal.ProviderSettings{
+		Timeout: 5 * time.Second,
+		Cache:   internal.CacheSettings{Path: filepath.Join(t.TempDir(), "resource.json")},
+	}
+
+	healthy := internal.NewResourceProvider(zap.NewNop(), settings, newDetector(newFakeIMDS(t, map[string]string{"instance-id": "i-1"}).URL))
+	want, _, err := healthy.Get(context.Background(), api.Client())
+	require.NoError(t, err)
+	wantDevices := healthy.VolumeDevices()
+	require.NotEmpty(t, wantDevices)
+	require.NoError(t, healthy.Shutdown(context.Background()))
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(down.Close)
+	provider := internal.NewResourceProvider(zap.NewNop(), settings, newDetector(down.URL))
+	t.Cleanup(func() { assert.NoError(t, provider.Shutdown(context.Background())) })
+
+	got, _, err := provider.Get(context.Background(), api.Client())
+	require.NoError(t, err)
+	assert.Equal(t, want.Attributes().AsRaw(), got.Attributes().AsRaw())
+	assert.Equal(t, wantDevices, provider.VolumeDevices())
+}
+
+func TestDetect_clearsVolumeDevicesWithoutIdentity(t *testing.T) {
+	var identityDown atomic.Bool
+	fake := fakeIMDSHandler(map[string]string{"instance-id": "i-1"})
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identityDown.Load() && r.URL.Path == "/latest/dynamic/instance-identity/document" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake(w, r)
+	}))
+	t.Cleanup(imds.Close)
+	api := newFakeEC2API(t, map[string]int{})
+
+	filter, err := newTagFilter([]string{"*"}, nil)
+	require.NoError(t, err)
+	d := &Detector{
+		metadataProvider:  ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(imds.URL), ec2provider.WithMaxRetries(0)),
+		fetchOptions:      fetchOptions{filter: filter, logger: zap.NewNop()},
+		apiSettings:       apiSettings{endpoint: api.URL},
+		keyStyle:          KeyStyleCloudWatch,
+		logger:            zap.NewNop(),
+		resolveEBSDevices: true,
+		sysBlockPath:      t.TempDir(),
+	}
+
+	_, _, err = d.Detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"xvda": "vol-1", "sda": "vol-1"}, d.VolumeDevices())
+
+	identityDown.Store(true)
+	res, _, err := d.Detect(context.Background())
+	var partial *internal.PartialResultError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, map[string]interface{}{MetadataKeyInstanceId: "i-1"}, res.Attributes().AsRaw())
+	assert.Nil(t, d.VolumeDevices())
+}
+
+func TestNewDetector_metadataEndpoint(t *testing.T) {
+	endpoint := newFakeIMDS(t, map[string]string{"instance-id": "i-1"})
+	imds := newFakeIMDS(t, map[string]string{"instance-id": "i-2"})
+
+	tests := []struct {
+		name       string
+		endpoint   string
+		imdsConfig IMDSConfig
+		want       string
+	}{
+		{name: "endpoint", endpoint: endpoint.URL, want: "i-1"},
+		{name: "imds endpoint", endpoint: endpoint.URL, imdsConfig: IMDSConfig{Endpoint: imds.URL}, want: "i-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateDefaultConfig()
+			cfg.Endpoint = tt.endpoint
+			cfg.IMDS = tt.imdsConfig
+			detector, err := NewDetector(componenttest.NewNopProcessorCreateSettings(), cfg)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = detector.(*Detector).Close() })
+			id, err := detector.(*Detector).InstanceID(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, id)
+		})
+	}
+}
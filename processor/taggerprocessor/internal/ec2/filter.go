@@ -0,0 +1,102 @@
+package ec2
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/multierr"
+)
+
+const (
+	allTagsPattern = "*"
+	regexpPrefix   = "regexp:"
+)
+
+type tagMatcher func(key string) bool
+
+// tagFilter decides which instance tags are copied into the detected resource.
+// A tag is kept when it matches at least one include pattern and no exclude pattern.
+type tagFilter struct {
+	include []tagMatcher
+	exclude []tagMatcher
+}
+
+func newTagFilter(include, exclude []string) (*tagFilter, error) {
+	includeMatchers, includeErr := compileTagPatterns("include_tags", include)
+	excludeMatchers, excludeErr := compileTagPatterns("exclude_tags", exclude)
+	if err := multierr.Append(includeErr, excludeErr); err != nil {
+		return nil, err
+	}
+	return &tagFilter{include: includeMatchers, exclude: excludeMatchers}, nil
+}
+
+// compileTagPatterns compiles every pattern, returning all errors prefixed with key and
+// the index of the pattern.
+func compileTagPatterns(key string, patterns []string) ([]tagMatcher, error) {
+	matchers := make([]tagMatcher, 0, len(patterns))
+	var errs error
+	for i, pattern := range patterns {
+		matcher, err := compileTagPattern(pattern)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("%s[%d]: %w", key, i, err))
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, errs
+}
+
+func compileTagPattern(pattern string) (tagMatcher, error) {
+	switch {
+	case pattern == allTagsPattern:
+		return func(string) bool { return true }, nil
+	case strings.HasPrefix(pattern, regexpPrefix):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexpPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	case strings.ContainsAny(pattern, "*?"):
+		return globToRegexp(pattern).MatchString, nil
+	default:
+		return func(key string) bool { return key == pattern }, nil
+	}
+}
+
+// keep reports whether the tag with the given key should be added to the resource.
+// A nil filter keeps every tag.
+func (f *tagFilter) keep(key string) bool {
+	if f == nil {
+		return true
+	}
+	return matchesAny(f.include, key) && !matchesAny(f.exclude, key)
+}
+
+func matchesAny(matchers []tagMatcher, key string) bool {
+	for _, matcher := range matchers {
+		if matcher(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a glob where "*" matches any run of characters and "?" matches
+// a single character. Unlike path.Match, "*" also matches "/", which is common in tag keys.
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
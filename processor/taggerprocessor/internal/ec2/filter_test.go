@@ -0,0 +1,39 @@
+package ec2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagFilter_keep(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		key     string
+		want    bool
+	}{
+		{name: "wildcard includes all", include: []string{"*"}, key: "Name", want: true},
+		{name: "no include patterns", key: "Name", want: false},
+		{name: "exact match", include: []string{"Name"}, key: "Name", want: true},
+		{name: "exact mismatch", include: []string{"Name"}, key: "Names", want: false},
+		{name: "glob matches slashes", include: []string{"kubernetes.io/*"}, key: "kubernetes.io/cluster/prod", want: true},
+		{name: "regexp", include: []string{"regexp:^team-[0-9]+$"}, key: "team-42", want: true},
+		{name: "exclude wins", include: []string{"*"}, exclude: []string{"aws:cloudformation:*"}, key: "aws:cloudformation:stack-name", want: false},
+		{name: "exclude leaves others", include: []string{"*"}, exclude: []string{"aws:cloudformation:*"}, key: "CostCenter", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newTagFilter(tt.include, tt.exclude)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, filter.keep(tt.key))
+		})
+	}
+}
+
+func TestTagFilter_invalidRegexp(t *testing.T) {
+	_, err := newTagFilter(nil, []string{"regexp:("})
+	assert.ErrorContains(t, err, "exclude_tags")
+}
@@ -0,0 +1,53 @@
+package ec2
+
+import (
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+
+	"poc/processor/taggerprocessor/internal"
+)
+
+// attributeWriter adds the attributes read from the detector sources to a resource.
+// When a source sets a key already set by an earlier source with a different value,
+// e.g. a tag named InstanceId, the detector merge policy decides which value is kept.
+type attributeWriter struct {
+	attr   pcommon.Map
+	policy internal.MergePolicy
+	// conflicts lists, by source, the keys whose value was kept under fail_on_conflict.
+	conflicts map[string][]string
+}
+
+func newAttributeWriter(attr pcommon.Map, policy internal.MergePolicy) *attributeWriter {
+	return &attributeWriter{attr: attr, policy: policy}
+}
+
+// put sets key to value as read from source.
+func (w *attributeWriter) put(source, key, value string) {
+	existing, ok := w.attr.Get(key)
+	switch {
+	case !ok:
+		w.attr.InsertString(key, value)
+	case existing.Type() == pcommon.ValueTypeString && existing.StringVal() == value:
+	case w.policy == internal.MergePolicyPreserve:
+	case w.policy == internal.MergePolicyFailOnConflict:
+		if w.conflicts == nil {
+			w.conflicts = make(map[string][]string)
+		}
+		w.conflicts[source] = append(w.conflicts[source], key)
+	default:
+		w.attr.UpsertString(key, value)
+	}
+}
+
+// failures reports the conflicts found under fail_on_conflict, one per source. They are
+// permanent: the same sources conflict again on the next attempt.
+func (w *attributeWriter) failures() []internal.SourceError {
+	var failures []internal.SourceError
+	for source, keys := range w.conflicts {
+		sort.Strings(keys)
+		failures = append(failures, internal.SourceError{Source: source, Err: &internal.ConflictError{Keys: keys}, Permanent: true})
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Source < failures[j].Source })
+	return failures
+}
@@ -0,0 +1,147 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	conventions "go.opentelemetry.io/collector/semconv/v1.6.1"
+	"go.uber.org/multierr"
+
+	ec2provider "poc/internal/ec2metadata"
+)
+
+// Network placement attribute keys in the CloudWatch style.
+const (
+	MetadataKeyAvailabilityZone   = "AvailabilityZone"
+	MetadataKeyAvailabilityZoneId = "AvailabilityZoneId"
+	MetadataKeyVpcId              = "VpcId"
+	MetadataKeySubnetId           = "SubnetId"
+	MetadataKeyPrivateIp          = "PrivateIp"
+	MetadataKeyMacAddress         = "MacAddress"
+	MetadataKeyPublicIp           = "PublicIp"
+)
+
+// Network placement attribute keys in the semantic convention style, for the values
+// that have no convention of their own.
+const (
+	attributeAWSEC2AvailabilityZoneID = "aws.ec2.availability_zone.id"
+	attributeAWSEC2VpcID              = "aws.ec2.vpc.id"
+	attributeAWSEC2SubnetID           = "aws.ec2.subnet.id"
+	attributeAWSEC2PrivateIP          = "aws.ec2.private_ip"
+	attributeAWSEC2MacAddress         = "aws.ec2.mac"
+	attributeAWSEC2PublicIP           = "aws.ec2.public_ip"
+)
+
+// NetworkConfig selects the network placement attributes added by the EC2 detector.
+// Every group is read from instance metadata and is off by default.
+type NetworkConfig struct {
+	// AvailabilityZone adds the availability zone and its ID, e.g. use1-az1, which
+	// names the same zone in every account.
+	AvailabilityZone bool `mapstructure:"availability_zone"`
+	// VPC adds the VPC and subnet IDs of the primary network interface.
+	VPC bool `mapstructure:"vpc"`
+	// PrivateIP adds the primary private IPv4 address.
+	PrivateIP bool `mapstructure:"private_ip"`
+	// MAC adds the MAC address of the primary network interface.
+	MAC bool `mapstructure:"mac"`
+	// PublicIP adds the public IPv4 address, if the instance has one.
+	PublicIP bool `mapstructure:"public_ip"`
+}
+
+func (cfg NetworkConfig) needsInterface() bool {
+	return cfg.VPC || cfg.PrivateIP || cfg.MAC || cfg.PublicIP
+}
+
+// networkAttribute is a network placement value and the keys it is emitted under.
+type networkAttribute struct {
+	cloudWatchKey string
+	semconvKey    string
+	value         string
+}
+
+// detectNetwork reads the enabled network placement groups. Values that could be read
+// are returned along with the errors of every path that failed.
+func detectNetwork(ctx context.Context, provider ec2provider.Provider, cfg NetworkConfig, availabilityZone string) ([]networkAttribute, error) {
+	var (
+		attrs []networkAttribute
+		errs  error
+	)
+	add := func(cloudWatchKey, semconvKey, value string) {
+		if value != "" {
+			attrs = append(attrs, networkAttribute{cloudWatchKey: cloudWatchKey, semconvKey: semconvKey, value: value})
+		}
+	}
+	get := func(path string) (string, bool) {
+		value, err := provider.GetMetadata(ctx, path)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("%s: %w", path, err))
+			return "", false
+		}
+		return value, true
+	}
+
+	if cfg.AvailabilityZone {
+		add(MetadataKeyAvailabilityZone, conventions.AttributeCloudAvailabilityZone, availabilityZone)
+		if azID, ok := get("placement/availability-zone-id"); ok {
+			add(MetadataKeyAvailabilityZoneId, attributeAWSEC2AvailabilityZoneID, azID)
+		}
+	}
+	if !cfg.needsInterface() {
+		return attrs, errs
+	}
+
+	// The MAC address of the primary network interface keys its subtree, so none of the
+	// interface values can be read without it.
+	mac, ok := get("mac")
+	if !ok {
+		return attrs, errs
+	}
+	iface := "network/interfaces/macs/" + mac + "/"
+	if cfg.MAC {
+		add(MetadataKeyMacAddress, attributeAWSEC2MacAddress, mac)
+	}
+	if cfg.VPC {
+		for _, field := range []struct{ path, cloudWatchKey, semconvKey string }{
+			{"vpc-id", MetadataKeyVpcId, attributeAWSEC2VpcID},
+			{"subnet-id", MetadataKeySubnetId, attributeAWSEC2SubnetID},
+		} {
+			if value, ok := get(iface + field.path); ok {
+				add(field.cloudWatchKey, field.semconvKey, value)
+			}
+		}
+	}
+	if cfg.PrivateIP {
+		if ips, ok := get(iface + "local-ipv4s"); ok {
+			add(MetadataKeyPrivateIp, attributeAWSEC2PrivateIP, firstLine(ips))
+		}
+	}
+	if cfg.PublicIP {
+		// Interfaces without a public address have no public-ipv4s entry.
+		ips, err := provider.GetMetadata(ctx, iface+"public-ipv4s")
+		if err != nil && !isNotFound(err) {
+			errs = multierr.Append(errs, fmt.Errorf("%s: %w", iface+"public-ipv4s", err))
+		}
+		add(MetadataKeyPublicIp, attributeAWSEC2PublicIP, firstLine(ips))
+	}
+	return attrs, errs
+}
+
+// insertNetworkAttributes adds attrs to attr under the keys of the given style.
+func insertNetworkAttributes(attr *attributeWriter, attrs []networkAttribute, keyStyle KeyStyle) {
+	for _, a := range attrs {
+		if keyStyle.cloudWatchKeys() {
+			attr.put(sourceNetwork, a.cloudWatchKey, a.value)
+		}
+		if keyStyle.semconvKeys() {
+			attr.put(sourceNetwork, a.semconvKey, a.value)
+		}
+	}
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
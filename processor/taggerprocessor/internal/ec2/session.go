@@ -0,0 +1,104 @@
+package ec2
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// apiSettings selects the region and credentials used to call the EC2 API.
+type apiSettings struct {
+	region                string
+	profile               string
+	sharedCredentialsFile string
+	roleARN               string
+	externalID            string
+	endpoint              string
+	useFIPSEndpoint       bool
+	useDualStackEndpoint  bool
+}
+
+func newAPISettings(cfg Config) apiSettings {
+	return apiSettings{
+		region:                cfg.Region,
+		profile:               cfg.Profile,
+		sharedCredentialsFile: cfg.SharedCredentialsFile,
+		roleARN:               cfg.RoleARN,
+		externalID:            cfg.ExternalID,
+		endpoint:              cfg.Endpoint,
+		useFIPSEndpoint:       cfg.UseFIPSEndpoint,
+		useDualStackEndpoint:  cfg.UseDualStackEndpoint,
+	}
+}
+
+// newEC2Client returns an EC2 API client for the given region, unless the settings
+// override it, using the configured endpoint or the FIPS and dual-stack variants of
+// the regional one. Credentials come from the default chain, the configured profile or
+// credentials file, and are used to assume the configured role if there is one.
+func newEC2Client(region string, client *http.Client, settings apiSettings) (*ec2.EC2, error) {
+	if settings.region != "" {
+		region = settings.region
+	}
+	opts := session.Options{
+		Config: aws.Config{
+			Region:     aws.String(region),
+			HTTPClient: client,
+		},
+		Profile: settings.profile,
+	}
+	if settings.profile != "" {
+		// Read the profile from the shared config file too, not only the credentials file,
+		// so that role_arn, credential_process and SSO settings of the profile apply.
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	if settings.sharedCredentialsFile != "" {
+		opts.Config.Credentials = credentials.NewSharedCredentials(settings.sharedCredentialsFile, settings.profile)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The endpoint settings only apply to the EC2 API; STS, which assumes the role,
+	// keeps its regular endpoint. Retries are left to callAPI, which paces them.
+	cfg := &aws.Config{MaxRetries: aws.Int(0)}
+	if settings.endpoint != "" {
+		cfg.Endpoint = aws.String(settings.endpoint)
+	}
+	if settings.useFIPSEndpoint {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if settings.useDualStackEndpoint {
+		cfg.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	if settings.roleARN != "" {
+		cfg.Credentials = stscreds.NewCredentials(sess, settings.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			if settings.externalID != "" {
+				p.ExternalID = aws.String(settings.externalID)
+			}
+		})
+	}
+	return ec2.New(sess, cfg), nil
+}
+
+// apiClient returns the detector's EC2 API client. It is only rebuilt when the region or
+// HTTP client changes, so that the credentials, and any assumed role session, are reused
+// across detections rather than fetched again on every refresh.
+func (d *Detector) apiClient(region string, httpClient *http.Client) (*ec2.EC2, error) {
+	d.apiLock.Lock()
+	defer d.apiLock.Unlock()
+	if d.api != nil && d.apiRegion == region && d.apiHTTPClient == httpClient {
+		return d.api, nil
+	}
+	api, err := newEC2Client(region, httpClient, d.apiSettings)
+	if err != nil {
+		return nil, err
+	}
+	d.api, d.apiRegion, d.apiHTTPClient = api, region, httpClient
+	return api, nil
+}
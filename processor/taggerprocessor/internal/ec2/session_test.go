@@ -0,0 +1,244 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// redirectTransport sends every request to target, whatever its URL, and records the
+// host each request was meant for.
+type redirectTransport struct {
+	target *url.URL
+
+	lock  sync.Mutex
+	hosts []string
+}
+
+func (rt *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.lock.Lock()
+	rt.hosts = append(rt.hosts, r.URL.Host)
+	rt.lock.Unlock()
+
+	r = r.Clone(r.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (rt *redirectTransport) requestedHosts() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]string(nil), rt.hosts...)
+}
+
+// newRedirectedClient returns an HTTP client sending every request to srv.
+func newRedirectedClient(t *testing.T, srv *httptest.Server) (*http.Client, *redirectTransport) {
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	rt := &redirectTransport{target: target}
+	return &http.Client{Transport: rt}, rt
+}
+
+// writeCredentials writes a shared credentials file with one profile and returns its path.
+func writeCredentials(t *testing.T, profile, accessKeyID string) string {
+	path := filepath.Join(t.TempDir(), "credentials")
+	content := fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = secret\n", profile, accessKeyID)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// writeConfig writes a shared config file with one profile and returns its path.
+func writeConfig(t *testing.T, profile, accessKeyID string) string {
+	path := filepath.Join(t.TempDir(), "config")
+	content := fmt.Sprintf("[profile %s]\nregion = us-east-1\naws_access_key_id = %s\naws_secret_access_key = secret\n", profile, accessKeyID)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestNewEC2Client_credentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	tests := []struct {
+		name        string
+		settings    func(t *testing.T) apiSettings
+		accessKeyID string
+	}{
+		{
+			name:        "default chain",
+			settings:    func(*testing.T) apiSettings { return apiSettings{} },
+			accessKeyID: "AKIAENV",
+		},
+		{
+			name: "credentials file",
+			settings: func(t *testing.T) apiSettings {
+				return apiSettings{sharedCredentialsFile: writeCredentials(t, "default", "AKIAFILE")}
+			},
+			accessKeyID: "AKIAFILE",
+		},
+		{
+			name: "credentials file and profile",
+			settings: func(t *testing.T) apiSettings {
+				return apiSettings{sharedCredentialsFile: writeCredentials(t, "tagging", "AKIATAGGING"), profile: "tagging"}
+			},
+			accessKeyID: "AKIATAGGING",
+		},
+		{
+			name: "profile",
+			settings: func(t *testing.T) apiSettings {
+				t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeCredentials(t, "tagging", "AKIAPROFILE"))
+				t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+				return apiSettings{profile: "tagging"}
+			},
+			accessKeyID: "AKIAPROFILE",
+		},
+		{
+			name: "config file profile",
+			settings: func(t *testing.T) apiSettings {
+				t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+				t.Setenv("AWS_CONFIG_FILE", writeConfig(t, "tagging", "AKIACONFIG"))
+				return apiSettings{profile: "tagging"}
+			},
+			accessKeyID: "AKIACONFIG",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, err := newEC2Client("us-east-1", http.DefaultClient, tt.settings(t))
+			require.NoError(t, err)
+			creds, err := svc.Config.Credentials.Get()
+			require.NoError(t, err)
+			assert.Equal(t, tt.accessKeyID, creds.AccessKeyID)
+		})
+	}
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<AssumeRoleResult><Credentials><AccessKeyId>ASIAASSUMED</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+<SessionToken>token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials>
+<AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/tagging/session</Arn><AssumedRoleId>AROA:session</AssumedRoleId></AssumedRoleUser>
+</AssumeRoleResult></AssumeRoleResponse>`
+
+// fakeAWS stands in for both STS and the EC2 API.
+type fakeAWS struct {
+	lock        sync.Mutex
+	calls       map[string]int
+	externalIDs []string
+	// ec2Credentials lists the access key IDs that signed the EC2 API requests.
+	ec2Credentials []string
+}
+
+func newFakeAWS(t *testing.T) (*fakeAWS, *httptest.Server) {
+	fake := &fakeAWS{calls: map[string]int{}}
+	ec2API := fakeEC2APIHandler(t, map[string]int{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		action := r.Form.Get("Action")
+
+		fake.lock.Lock()
+		fake.calls[action]++
+		if action == "AssumeRole" {
+			fake.externalIDs = append(fake.externalIDs, r.Form.Get("ExternalId"))
+		} else {
+			credential := strings.SplitN(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/", 2)[0]
+			fake.ec2Credentials = append(fake.ec2Credentials, credential)
+		}
+		fake.lock.Unlock()
+
+		if action == "AssumeRole" {
+			fmt.Fprint(w, assumeRoleResponse)
+			return
+		}
+		ec2API(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func TestDetector_assumeRole(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAINSTANCE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	// A custom CA bundle can only be loaded into an *http.Transport.
+	t.Setenv("AWS_CA_BUNDLE", "")
+	fake, srv := newFakeAWS(t)
+	client, transport := newRedirectedClient(t, srv)
+
+	filter, err := newTagFilter([]string{"*"}, nil)
+	require.NoError(t, err)
+	d := &Detector{apiSettings: apiSettings{
+		roleARN:    "arn:aws:iam::123456789012:role/tagging",
+		externalID: "tagging-external-id",
+	}}
+	for i := 0; i < 2; i++ {
+		got, _, err := d.connectAndFetchEc2TagsandEcsVolume(context.Background(), "us-east-1", "i-1", client,
+			fetchOptions{filter: filter, logger: zap.NewNop()})
+		require.NoError(t, err)
+		assert.Equal(t, "web", got["Name"])
+	}
+
+	assert.Equal(t, 1, fake.calls["AssumeRole"], "the assumed role session should be reused")
+	assert.Equal(t, []string{"tagging-external-id"}, fake.externalIDs)
+	assert.Equal(t, []string{"ASIAASSUMED", "ASIAASSUMED", "ASIAASSUMED", "ASIAASSUMED"}, fake.ec2Credentials)
+	hosts := transport.requestedHosts()
+	assert.Contains(t, hosts[0], "sts.")
+	for _, host := range hosts[1:] {
+		assert.Equal(t, "ec2.us-east-1.amazonaws.com", host)
+	}
+}
+
+// TestNewEC2Client_endpointSettings checks that the endpoint settings apply to the EC2 API
+// only, not to STS when assuming a role.
+func TestNewEC2Client_endpointSettings(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAINSTANCE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	tests := []struct {
+		name     string
+		settings apiSettings
+		ec2Host  string
+	}{
+		{
+			name:     "endpoint",
+			settings: apiSettings{endpoint: "https://vpce-0123-abcd.ec2.us-east-1.vpce.amazonaws.com"},
+			ec2Host:  "vpce-0123-abcd.ec2.us-east-1.vpce.amazonaws.com",
+		},
+		{
+			name:     "fips",
+			settings: apiSettings{useFIPSEndpoint: true},
+			ec2Host:  "ec2-fips.us-east-1.amazonaws.com",
+		},
+		{
+			name:     "dual-stack",
+			settings: apiSettings{useDualStackEndpoint: true},
+			ec2Host:  "ec2.us-east-1.api.aws",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := newFakeAWS(t)
+			client, transport := newRedirectedClient(t, srv)
+			settings := tt.settings
+			settings.roleARN = "arn:aws:iam::123456789012:role/tagging"
+
+			svc, err := newEC2Client("us-east-1", client, settings)
+			require.NoError(t, err)
+			_, err = svc.DescribeTagsWithContext(context.Background(), &ec2.DescribeTagsInput{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"sts.amazonaws.com", tt.ec2Host}, transport.requestedHosts())
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package ec2
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"poc/processor/taggerprocessor/internal"
+)
+
+// Sources the EC2 detector reads from, as named in partial result reports.
+const (
+	sourceIdentityDocument = "identity_document"
+	sourceHostname         = "hostname"
+	sourceTags             = "tags"
+	sourceIMDSTags         = "imds_tags"
+	sourceNetwork          = "network"
+	sourceLifecycleState   = "lifecycle_state"
+	sourceVolumes          = "volumes"
+)
+
+// permanentErrorCodes are EC2 API error codes that retrying will not fix.
+var permanentErrorCodes = map[string]bool{
+	"UnauthorizedOperation": true,
+	"AuthFailure":           true,
+	"AccessDenied":          true,
+	"OptInRequired":         true,
+}
+
+func newSourceError(source string, err error) internal.SourceError {
+	var aerr awserr.Error
+	// Instance metadata answers 404 when access to tags in instance metadata is disabled.
+	permanent := errors.As(err, &aerr) && permanentErrorCodes[aerr.Code()] || isNotFound(err)
+	return internal.SourceError{Source: source, Err: err, Permanent: permanent}
+}
+
+// isNotFound reports whether err is a 404 answer, which instance metadata returns for
+// paths that do not apply to the instance.
+func isNotFound(err error) bool {
+	var rerr awserr.RequestFailure
+	return errors.As(err, &rerr) && rerr.StatusCode() == http.StatusNotFound
+}
@@ -0,0 +1,239 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultAPIRateLimit is the default number of EC2 API calls per second.
+	defaultAPIRateLimit = 5.0
+	// maxAPIJitter bounds api_jitter, which delays the first EC2 API call.
+	maxAPIJitter = 5 * time.Minute
+	// minAPIRate is the rate the limiter backs off to under sustained throttling.
+	minAPIRate = 0.1
+	// maxAPIRetries is how many times a throttled or failed call is retried before giving up
+	// and leaving it to the provider's retry_on_failure.
+	maxAPIRetries = 5
+)
+
+// newThrottleBackOff returns the backoff between retries of a throttled call. Tests
+// replace it to retry without waiting.
+var newThrottleBackOff = func() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 500 * time.Millisecond
+	b.MaxInterval = 10 * time.Second
+	b.MaxElapsedTime = 0
+	// A randomization factor of 1 spreads the retries of a fleet over the whole interval.
+	b.RandomizationFactor = 1
+	b.Reset()
+	return b
+}
+
+// apiLimiter paces the EC2 API calls of every detector in the process, so that several
+// processors together stay within the configured rate.
+var apiLimiter = &adaptiveLimiter{}
+
+// errAPIRateLimited is returned when the rate limit leaves no time for a call before the
+// detector timeout.
+var errAPIRateLimited = errors.New("EC2 API rate limit leaves no time for the call before the detector timeout")
+
+// adaptiveLimiter spaces calls evenly at a rate that is halved whenever a call is
+// throttled and recovers gradually after each successful call. A nil limiter, or one
+// with a zero limit, does not limit.
+type adaptiveLimiter struct {
+	lock    sync.Mutex
+	maxRate float64
+	rate    float64
+	next    time.Time
+	// users holds the limit of each detector sharing the limiter; the lowest applies.
+	users    map[int]float64
+	nextUser int
+}
+
+// newAdaptiveLimiter returns a limiter allowing up to ratePerSecond calls per second, or
+// nil if ratePerSecond is zero.
+func newAdaptiveLimiter(ratePerSecond float64) *adaptiveLimiter {
+	if ratePerSecond <= 0 {
+		return nil
+	}
+	return &adaptiveLimiter{maxRate: ratePerSecond, rate: ratePerSecond}
+}
+
+// acquire adds a user allowing up to ratePerSecond calls per second, zero meaning no
+// limit, and returns the function removing it. The limit is recomputed on both, so
+// that it is never stricter than the strictest current user asks for.
+func (l *adaptiveLimiter) acquire(ratePerSecond float64) (release func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.users == nil {
+		l.users = make(map[int]float64)
+	}
+	user := l.nextUser
+	l.nextUser++
+	l.users[user] = ratePerSecond
+	l.updateMaxRate()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			delete(l.users, user)
+			l.updateMaxRate()
+		})
+	}
+}
+
+// updateMaxRate sets the limit to the lowest limit of the users. l.lock must be held.
+func (l *adaptiveLimiter) updateMaxRate() {
+	maxRate := 0.0
+	for _, rate := range l.users {
+		if rate > 0 && (maxRate == 0 || rate < maxRate) {
+			maxRate = rate
+		}
+	}
+	// A rate that is not being lowered by throttling follows the new limit at once.
+	if maxRate == 0 || l.rate == l.maxRate || l.rate > maxRate {
+		l.rate = maxRate
+	}
+	l.maxRate = maxRate
+}
+
+// wait blocks until the next call may be made or ctx is done. It fails at once with
+// errAPIRateLimited if the call may only be made after the deadline of ctx.
+func (l *adaptiveLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	if l.maxRate == 0 {
+		l.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	if deadline, ok := ctx.Deadline(); ok && slot.After(deadline) {
+		l.lock.Unlock()
+		return errAPIRateLimited
+	}
+	l.next = slot.Add(time.Duration(float64(time.Second) / l.rate))
+	l.lock.Unlock()
+
+	return sleep(ctx, slot.Sub(now))
+}
+
+func (l *adaptiveLimiter) onThrottle() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxRate == 0 {
+		return
+	}
+	l.rate /= 2
+	if l.rate < minAPIRate {
+		l.rate = minAPIRate
+	}
+}
+
+func (l *adaptiveLimiter) onSuccess() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// Recover by a tenth of the limit per call, so that a throttled fleet ramps up slowly.
+	l.rate += l.maxRate / 10
+	if l.rate > l.maxRate {
+		l.rate = l.maxRate
+	}
+}
+
+// callAPI makes an EC2 API call through the limiter, retrying it with exponential backoff
+// and jitter while it is throttled, e.g. with RequestLimitExceeded, or fails with an
+// error the SDK would retry. The EC2 client does not retry on its own, so that every
+// attempt goes through the limiter. Retries stop early, returning the last error, when
+// the backoff would run past the deadline of ctx.
+func callAPI(ctx context.Context, opts fetchOptions, call func() error) error {
+	b := newThrottleBackOff()
+	for retries := 0; ; retries++ {
+		if err := opts.limiter.wait(ctx); err != nil {
+			return err
+		}
+		err := call()
+		throttled := request.IsErrorThrottle(err)
+		switch {
+		case err == nil:
+			opts.limiter.onSuccess()
+			return nil
+		case throttled:
+			opts.limiter.onThrottle()
+		case !isRetryable(err):
+			return err
+		}
+		delay := b.NextBackOff()
+		if retries >= maxAPIRetries || !beforeDeadline(ctx, delay) {
+			return err
+		}
+		opts.logger.Debug("EC2 API call failed, retrying", zap.Bool("throttled", throttled),
+			zap.Duration("delay", delay), zap.Error(err))
+		if sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// isRetryable reports whether the SDK's default retryer would retry err: connection
+// errors, throttling and server errors other than 501 Not Implemented.
+func isRetryable(err error) bool {
+	if request.IsErrorRetryable(err) {
+		return true
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() >= 500 && reqErr.StatusCode() != http.StatusNotImplemented
+	}
+	return false
+}
+
+// beforeDeadline reports whether waiting for d leaves ctx before its deadline, if any.
+func beforeDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(d).Before(deadline)
+}
+
+// randomDelay returns a random delay shorter than maxDelay.
+func randomDelay(maxDelay time.Duration) time.Duration {
+	if maxDelay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxDelay)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,219 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/zap"
+)
+
+// withoutBackOff retries throttled calls right away for the rest of the test.
+func withoutBackOff(t *testing.T) {
+	original := newThrottleBackOff
+	newThrottleBackOff = func() backoff.BackOff { return &backoff.ZeroBackOff{} }
+	t.Cleanup(func() { newThrottleBackOff = original })
+}
+
+// waitN returns how long n calls to wait take.
+func waitN(t *testing.T, l *adaptiveLimiter, n int) time.Duration {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		require.NoError(t, l.wait(context.Background()))
+	}
+	return time.Since(start)
+}
+
+func TestAdaptiveLimiter_pacing(t *testing.T) {
+	assert.Nil(t, newAdaptiveLimiter(0))
+	var unlimited *adaptiveLimiter
+	assert.Less(t, waitN(t, unlimited, 100), 50*time.Millisecond)
+
+	// 100 calls per second are 10ms apart; the first call does not wait.
+	assert.GreaterOrEqual(t, waitN(t, newAdaptiveLimiter(100), 5), 40*time.Millisecond)
+
+	// A throttled call halves the rate.
+	throttled := newAdaptiveLimiter(100)
+	throttled.onThrottle()
+	assert.GreaterOrEqual(t, waitN(t, throttled, 5), 80*time.Millisecond)
+
+	// The rate recovers by a tenth of the limit per successful call, up to the limit.
+	recovered := newAdaptiveLimiter(100)
+	recovered.onThrottle()
+	for i := 0; i < 10; i++ {
+		recovered.onSuccess()
+	}
+	assert.Less(t, waitN(t, recovered, 5), 80*time.Millisecond)
+}
+
+func TestAdaptiveLimiter_sharedLimit(t *testing.T) {
+	limiter := &adaptiveLimiter{}
+	releaseFast := limiter.acquire(100)
+	releaseSlow := limiter.acquire(50)
+	releaseUnlimited := limiter.acquire(0)
+
+	// The lowest limit applies: 50 calls per second are 20ms apart.
+	assert.GreaterOrEqual(t, waitN(t, limiter, 5), 80*time.Millisecond)
+
+	// Once the strictest user is gone, the limit is raised again.
+	releaseSlow()
+	releaseSlow()
+	time.Sleep(20 * time.Millisecond)
+	assert.Less(t, waitN(t, limiter, 5), 80*time.Millisecond)
+
+	// Users without a limit leave it to the others; with none left, nothing is limited.
+	releaseFast()
+	time.Sleep(10 * time.Millisecond)
+	assert.Less(t, waitN(t, limiter, 100), 50*time.Millisecond)
+	releaseUnlimited()
+}
+
+// TestNewDetector_sharesLimiter checks that detectors of different processors share
+// one rate limit.
+func TestNewDetector_sharesLimiter(t *testing.T) {
+	set := componenttest.NewNopProcessorCreateSettings()
+	fastCfg := CreateDefaultConfig()
+	fastCfg.APIRateLimit = 100
+	slowCfg := CreateDefaultConfig()
+	slowCfg.APIRateLimit = 50
+
+	fast, err := NewDetector(set, fastCfg)
+	require.NoError(t, err)
+	slow, err := NewDetector(set, slowCfg)
+	require.NoError(t, err)
+	limiter := fast.(*Detector).fetchOptions.limiter
+	require.Same(t, limiter, slow.(*Detector).fetchOptions.limiter)
+	assert.GreaterOrEqual(t, waitN(t, limiter, 5), 80*time.Millisecond)
+
+	require.NoError(t, slow.(*Detector).Close())
+	require.NoError(t, fast.(*Detector).Close())
+	time.Sleep(20 * time.Millisecond)
+	assert.Less(t, waitN(t, limiter, 100), 50*time.Millisecond)
+}
+
+func TestAdaptiveLimiter_deadline(t *testing.T) {
+	limiter := newAdaptiveLimiter(1)
+	require.NoError(t, limiter.wait(context.Background()))
+
+	// The next call is only allowed in a second, after the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, errAPIRateLimited, limiter.wait(ctx))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestCallAPI(t *testing.T) {
+	withoutBackOff(t)
+	throttle := awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	unavailable := awserr.NewRequestFailure(awserr.New("Unavailable", "Service unavailable.", nil), http.StatusServiceUnavailable, "")
+	denied := awserr.NewRequestFailure(awserr.New("UnauthorizedOperation", "Not authorized.", nil), http.StatusForbidden, "")
+
+	tests := []struct {
+		name    string
+		errs    []error
+		calls   int
+		wantErr error
+	}{
+		{name: "success", calls: 1},
+		{name: "throttled then success", errs: []error{throttle, throttle}, calls: 3},
+		{name: "unavailable then success", errs: []error{unavailable}, calls: 2},
+		{name: "always throttled", errs: []error{throttle, throttle, throttle, throttle, throttle, throttle, throttle}, calls: maxAPIRetries + 1, wantErr: throttle},
+		{name: "not retryable", errs: []error{denied}, calls: 1, wantErr: denied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := callAPI(context.Background(), fetchOptions{logger: zap.NewNop()}, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
+
+// TestCallAPI_deadline checks that retries stop with the API error rather than running
+// into the deadline.
+func TestCallAPI_deadline(t *testing.T) {
+	original := newThrottleBackOff
+	newThrottleBackOff = func() backoff.BackOff { return backoff.NewConstantBackOff(time.Second) }
+	t.Cleanup(func() { newThrottleBackOff = original })
+	throttle := awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := callAPI(ctx, fetchOptions{logger: zap.NewNop()}, func() error {
+		calls++
+		return throttle
+	})
+	assert.Equal(t, throttle, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDetector_waitAPIJitter(t *testing.T) {
+	d := &Detector{apiJitter: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The delay is cut to half the time left before the deadline.
+	start := time.Now()
+	require.NoError(t, d.waitAPIJitter(ctx))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Only the first API call is delayed.
+	start = time.Now()
+	require.NoError(t, d.waitAPIJitter(context.Background()))
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+}
+
+// TestCallAPI_errorKeepsRate checks that a failed call does not count as a success that
+// raises the rate of a throttled limiter.
+func TestCallAPI_errorKeepsRate(t *testing.T) {
+	limiter := newAdaptiveLimiter(100)
+	limiter.onThrottle()
+	denied := awserr.New("UnauthorizedOperation", "Not authorized.", nil)
+	err := callAPI(context.Background(), fetchOptions{limiter: limiter, logger: zap.NewNop()}, func() error { return denied })
+	require.Equal(t, denied, err)
+
+	// Still 50 calls per second, that is 20ms apart, after the call above.
+	assert.GreaterOrEqual(t, waitN(t, limiter, 4), 80*time.Millisecond)
+}
+
+// TestNewEC2Client_noSDKRetries checks that throttled calls are only retried by callAPI,
+// not also by the SDK.
+func TestNewEC2Client_noSDKRetries(t *testing.T) {
+	withoutBackOff(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>RequestLimitExceeded</Code>`+
+			`<Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := newEC2Client("us-east-1", srv.Client(), apiSettings{endpoint: srv.URL})
+	require.NoError(t, err)
+	_, err = describeTags(context.Background(), svc, "i-1", fetchOptions{logger: zap.NewNop()})
+	var aerr awserr.Error
+	require.ErrorAs(t, err, &aerr)
+	assert.Equal(t, "RequestLimitExceeded", aerr.Code())
+	assert.EqualValues(t, maxAPIRetries+1, requests.Load())
+}
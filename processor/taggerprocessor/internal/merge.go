@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergePolicy controls what happens when a detected attribute already exists, with a
+// different value, on the resource it is merged into.
+type MergePolicy string
+
+const (
+	// MergePolicyOverride replaces the existing value with the detected one.
+	MergePolicyOverride MergePolicy = "override"
+	// MergePolicyPreserve keeps the existing value.
+	MergePolicyPreserve MergePolicy = "preserve"
+	// MergePolicyFailOnConflict merges nothing and reports an error.
+	MergePolicyFailOnConflict MergePolicy = "fail_on_conflict"
+)
+
+// Validate checks that p is a known merge policy. The empty policy is treated as override.
+func (p MergePolicy) Validate() error {
+	switch p {
+	case "", MergePolicyOverride, MergePolicyPreserve, MergePolicyFailOnConflict:
+		return nil
+	default:
+		return fmt.Errorf("unknown merge policy %q, must be one of %q, %q or %q",
+			p, MergePolicyOverride, MergePolicyPreserve, MergePolicyFailOnConflict)
+	}
+}
+
+// ConflictError is returned by MergeResource under MergePolicyFailOnConflict.
+type ConflictError struct {
+	Keys []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicting resource attributes: %s", strings.Join(e.Keys, ", "))
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceError describes the failure of one of the sources a detector reads from.
+type SourceError struct {
+	// Source names the failed source, e.g. "tags".
+	Source string
+	// Err is the underlying error.
+	Err error
+	// Permanent marks failures that retrying will not fix, such as a missing IAM permission.
+	Permanent bool
+}
+
+func (e SourceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Source, e.Err)
+}
+
+// PartialResultError is returned by a detector together with the part of the resource
+// it could still detect. Unlike other errors, the accompanying resource is merged.
+type PartialResultError struct {
+	Failures []SourceError
+}
+
+func (e *PartialResultError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		msgs = append(msgs, failure.Error())
+	}
+	return fmt.Sprintf("partially detected resource, failed sources: %s", strings.Join(msgs, "; "))
+}
+
+// Retryable reports whether any of the failures may succeed on a later attempt.
+func (e *PartialResultError) Retryable() bool {
+	for _, failure := range e.Failures {
+		if !failure.Permanent {
+			return true
+		}
+	}
+	return false
+}
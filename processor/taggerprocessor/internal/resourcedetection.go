@@ -2,12 +2,17 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
@@ -17,13 +22,20 @@ type Detector interface {
 	Detect(ctx context.Context) (resource pcommon.Resource, schemaURL string, err error)
 }
 
+// VolumeDeviceMapper is implemented by detectors that can map local block device names
+// to the IDs of the volumes behind them. VolumeDevices returns the mapping found by the
+// last call to Detect.
+type VolumeDeviceMapper interface {
+	VolumeDevices() map[string]string
+}
+
 type DetectorConfig interface{}
 
 type ResourceDetectorConfig interface {
 	GetConfigFromType(DetectorType) DetectorConfig
 }
 
-type DetectorFactory func(component.ProcessorCreateSettings) (Detector, error)
+type DetectorFactory func(component.ProcessorCreateSettings, DetectorConfig) (Detector, error)
 
 type ResourceProviderFactory struct {
 	// detectors holds all possible detector types.
@@ -34,89 +46,433 @@ func NewProviderFactory(detectors map[DetectorType]DetectorFactory) *ResourcePro
 	return &ResourceProviderFactory{detectors: detectors}
 }
 
+// ProviderSettings configures how a ResourceProvider runs its detectors.
+type ProviderSettings struct {
+	// Timeout bounds the run of each detector.
+	Timeout time.Duration
+	// RefreshInterval is how often detection is re-run in the background; zero disables it.
+	RefreshInterval time.Duration
+	// MergePolicy controls how the output of each detector is merged with earlier ones.
+	MergePolicy MergePolicy
+	// DetectorTimeouts optionally bounds the run time of individual detectors instead of
+	// Timeout, which they may shorten or extend.
+	DetectorTimeouts map[DetectorType]time.Duration
+	// Retry configures retrying a failed initial detection in the background.
+	Retry RetrySettings
+	// Cache configures the on-disk fallback used when detection fails at startup.
+	Cache CacheSettings
+	// FailOnError means a failed initial detection stops the collector from starting, so
+	// no retry or refresh is started after it.
+	FailOnError bool
+}
+
 func (f *ResourceProviderFactory) CreateResourceProvider(
 	params component.ProcessorCreateSettings,
-	timeout time.Duration,
+	settings ProviderSettings,
+	detectorConfigs ResourceDetectorConfig,
 	detectorTypes ...DetectorType) (*ResourceProvider, error) {
-	detectors, err := f.getDetectors(params, detectorTypes)
+	detectors, err := f.getDetectors(params, detectorConfigs, settings.DetectorTimeouts, detectorTypes)
 	if err != nil {
 		return nil, err
 	}
 
-	provider := NewResourceProvider(params.Logger, timeout, detectors...)
+	provider := NewResourceProvider(params.Logger, settings, detectors...)
 	return provider, nil
 }
 
-func (f *ResourceProviderFactory) getDetectors(params component.ProcessorCreateSettings, detectorTypes []DetectorType) ([]Detector, error) {
+func (f *ResourceProviderFactory) getDetectors(
+	params component.ProcessorCreateSettings,
+	detectorConfigs ResourceDetectorConfig,
+	detectorTimeouts map[DetectorType]time.Duration,
+	detectorTypes []DetectorType) ([]Detector, error) {
 	detectors := make([]Detector, 0, len(detectorTypes))
 	for _, detectorType := range detectorTypes {
 		detectorFactory, ok := f.detectors[detectorType]
 		if !ok {
+			_ = closeDetectors(detectors)
 			return nil, fmt.Errorf("invalid detector key: %v", detectorType)
 		}
 
-		detector, err := detectorFactory(params)
+		detector, err := detectorFactory(params, detectorConfigs.GetConfigFromType(detectorType))
 		if err != nil {
+			_ = closeDetectors(detectors)
 			return nil, fmt.Errorf("failed creating detector type %q: %w", detectorType, err)
 		}
 
+		if timeout := detectorTimeouts[detectorType]; timeout > 0 {
+			detector = &timeoutDetector{detector: detector, timeout: timeout}
+		}
+
 		detectors = append(detectors, detector)
 	}
 
 	return detectors, nil
 }
 
+// timeoutDetector bounds the run time of a single detector.
+type timeoutDetector struct {
+	detector Detector
+	timeout  time.Duration
+}
+
+// unwrapDetector returns the detector wrapped by a timeoutDetector, so that optional
+// interfaces of the configured detector can be checked.
+func unwrapDetector(detector Detector) Detector {
+	if td, ok := detector.(*timeoutDetector); ok {
+		return td.detector
+	}
+	return detector
+}
+
+func (d *timeoutDetector) Detect(ctx context.Context) (resource pcommon.Resource, schemaURL string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	return d.detector.Detect(ctx)
+}
+
 type ResourceProvider struct {
 	logger           *zap.Logger
 	timeout          time.Duration
+	refreshInterval  time.Duration
+	mergePolicy      MergePolicy
+	retry            RetrySettings
+	cache            CacheSettings
+	failOnError      bool
 	detectors        []Detector
 	detectedResource *resourceResult
+	// initErr is the outcome of the first detection, returned by every call to Get.
+	initErr error
+
+	// lock guards detectedResource, which is swapped by the refresh loop
+	// while processors read it.
+	lock     sync.RWMutex
+	once     sync.Once
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
 }
 
 type resourceResult struct {
-	resource  pcommon.Resource
-	schemaURL string
-	err       error
+	resource      pcommon.Resource
+	schemaURL     string
+	volumeDevices map[string]string
+	err           error
 }
 
-func NewResourceProvider(logger *zap.Logger, timeout time.Duration, detectors ...Detector) *ResourceProvider {
+func NewResourceProvider(logger *zap.Logger, settings ProviderSettings, detectors ...Detector) *ResourceProvider {
 	return &ResourceProvider{
-		logger:    logger,
-		timeout:   timeout,
-		detectors: detectors,
+		logger:          logger,
+		timeout:         settings.Timeout,
+		refreshInterval: settings.RefreshInterval,
+		mergePolicy:     settings.MergePolicy,
+		retry:           settings.Retry,
+		cache:           settings.Cache,
+		failOnError:     settings.FailOnError,
+		detectors:       detectors,
+		stopCh:          make(chan struct{}),
 	}
 }
 
+// Get runs the detectors the first time it is called and returns the detected resource.
+// If detection fails and retries are enabled, it is retried in the background, even if a
+// cached resource is served meanwhile; if a refresh interval is configured, detection is
+// then re-run periodically. Background work stops when Shutdown is called; use Current
+// to read the latest result.
+//
+// The returned error reports a failed initial detection, unless a cached resource was
+// used instead. Every call returns the outcome of that first detection.
 func (p *ResourceProvider) Get(ctx context.Context, client *http.Client) (resource pcommon.Resource, schemaURL string, err error) {
+	p.once.Do(func() {
+		// detectErr decides whether to retry, even when the cache hides it from callers.
+		result, detectErr := p.detectResource(ctx)
+		err := detectErr
+		if err == nil {
+			p.saveCache(ctx, result)
+		} else if cached := p.loadCache(ctx); cached != nil {
+			result = cached
+			err = nil
+		}
+		p.setDetectedResource(result)
+		p.initErr = err
+
+		p.logger.Info("detected resource information",
+			zap.Any("resource", AttributesToMap(result.resource.Attributes())),
+			zap.String("schema_url", result.schemaURL),
+			zap.Error(err))
+
+		if err != nil && p.failOnError {
+			return
+		}
+		retry := detectErr != nil && p.retry.Enabled
+		if retry || p.refreshInterval > 0 {
+			p.wg.Add(1)
+			go p.runInBackground(client, retry)
+		}
+	})
+
+	resource, schemaURL = p.Current()
+	return resource, schemaURL, p.initErr
+}
+
+// Current returns the most recently detected resource and schema URL. It returns an
+// empty resource if detection has not run yet.
+func (p *ResourceProvider) Current() (resource pcommon.Resource, schemaURL string) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.detectedResource == nil {
+		return pcommon.NewResource(), ""
+	}
+	return p.detectedResource.resource, p.detectedResource.schemaURL
+}
+
+// VolumeDevices returns the most recently detected mapping of local block device names to
+// volume IDs, or nil if no detector provides one.
+func (p *ResourceProvider) VolumeDevices() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.detectedResource == nil {
+		return nil
+	}
+	return p.detectedResource.volumeDevices
+}
+
+// Shutdown stops any background retry or refresh, waits for it to exit, closes the
+// detectors holding shared resources and drops the detected resource.
+func (p *ResourceProvider) Shutdown(_ context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+	p.wg.Wait()
+	p.setDetectedResource(nil)
+	return closeDetectors(p.detectors)
+}
+
+// closeDetectors closes the detectors implementing io.Closer, such as those sharing a
+// rate limit with the other detectors of the process.
+func closeDetectors(detectors []Detector) error {
+	var errs error
+	for _, detector := range detectors {
+		if closer, ok := unwrapDetector(detector).(io.Closer); ok {
+			errs = multierr.Append(errs, closer.Close())
+		}
+	}
+	return errs
+}
+
+func (p *ResourceProvider) setDetectedResource(result *resourceResult) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.detectedResource = result
+}
+
+// runInBackground retries the initial detection if requested, then keeps the result
+// fresh if a refresh interval is configured.
+func (p *ResourceProvider) runInBackground(client *http.Client, retry bool) {
+	defer p.wg.Done()
+
+	if retry {
+		p.retryDetection(client)
+	}
+	if p.refreshInterval > 0 {
+		p.refreshLoop(client)
+	}
+}
+
+// retryDetection re-runs detection with exponential backoff and jitter until it
+// succeeds, the retry budget is exhausted or the provider is shut down. Data keeps
+// flowing with the partial result in the meantime.
+func (p *ResourceProvider) retryDetection(client *http.Client) {
+	expBackoff := p.retry.newBackOff()
+	for {
+		wait := expBackoff.NextBackOff()
+		if wait == backoff.Stop {
+			p.logger.Warn("giving up retrying resource detection")
+			return
+		}
+
+		p.logger.Info("retrying resource detection", zap.Duration("interval", wait))
+		select {
+		case <-p.stopCh:
+			return
+		case <-time.After(wait):
+		}
+
+		if p.detectAndSwap(client, "failed to retry resource detection") {
+			p.logger.Info("resource detection succeeded after retrying")
+			return
+		}
+	}
+}
+
+func (p *ResourceProvider) refreshLoop(client *http.Client) {
+	ticker := time.NewTicker(p.refreshInterval)
+	defer ticker.Stop()
 
-	var cancel context.CancelFunc
-	ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.detectAndSwap(client, "failed to refresh resource information, keeping previous result")
+		}
+	}
+}
+
+// detectAndSwap re-runs detection and replaces the current result only if every
+// detector succeeded, so a transient failure never replaces a good result with a
+// partial one. It reports whether the result was replaced.
+func (p *ResourceProvider) detectAndSwap(client *http.Client, failureMsg string) bool {
+	ctx, cancel := context.WithCancel(ContextWithClient(context.Background(), client))
+	defer cancel()
+
+	// Stop in-flight detection as soon as the provider is shut down.
+	go func() {
+		select {
+		case <-p.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	result, err := p.detectResource(ctx)
+	if err != nil {
+		p.logger.Warn(failureMsg, zap.Error(err))
+		return false
+	}
+	p.setDetectedResource(result)
+	p.saveCache(ctx, result)
+	return true
+}
+
+// instanceID identifies the host using the first detector able to do so.
+func (p *ResourceProvider) instanceID(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
 	defer cancel()
-	p.detectResource(ctx)
+	for _, detector := range p.detectors {
+		if identifier, ok := unwrapDetector(detector).(InstanceIdentifier); ok {
+			return identifier.InstanceID(ctx)
+		}
+	}
+	return "", errors.New("no configured detector can identify the instance")
+}
+
+// saveCache writes a successfully detected, non-empty resource to the cache file.
+func (p *ResourceProvider) saveCache(ctx context.Context, result *resourceResult) {
+	if p.cache.Path == "" || IsEmptyResource(result.resource) {
+		return
+	}
 
-	return p.detectedResource.resource, p.detectedResource.schemaURL, p.detectedResource.err
+	instanceID, err := p.instanceID(ctx)
+	if err != nil {
+		p.logger.Debug("not caching detected resource", zap.Error(err))
+		return
+	}
+	if err = saveCachedResource(p.cache.Path, instanceID, result); err != nil {
+		p.logger.Warn("failed to save detected resource to cache", zap.String("path", p.cache.Path), zap.Error(err))
+	}
 }
 
-func (p *ResourceProvider) detectResource(ctx context.Context) {
-	p.detectedResource = &resourceResult{}
+// loadCache returns the cached resource if it belongs to this instance and has not
+// expired, or nil otherwise.
+func (p *ResourceProvider) loadCache(ctx context.Context) *resourceResult {
+	if p.cache.Path == "" {
+		return nil
+	}
 
+	instanceID, err := p.instanceID(ctx)
+	if err != nil {
+		p.logger.Warn("cannot use cached resource, instance identity unavailable", zap.Error(err))
+		return nil
+	}
+	result, timestamp, err := loadCachedResource(p.cache.Path, instanceID, p.cache.MaxAge)
+	if err != nil {
+		p.logger.Warn("cannot use cached resource", zap.String("path", p.cache.Path), zap.Error(err))
+		return nil
+	}
+
+	p.logger.Info("detection failed, using cached resource",
+		zap.String("path", p.cache.Path), zap.Time("cached_at", timestamp))
+	return result
+}
+
+// detectResource runs every detector concurrently and merges their output in the
+// configured order, so precedence does not depend on which detector finishes first.
+// The returned result always holds whatever could be detected; the error aggregates
+// detector failures, including retryable partial results.
+func (p *ResourceProvider) detectResource(ctx context.Context) (*resourceResult, error) {
 	res := pcommon.NewResource()
 	mergedSchemaURL := ""
+	var volumeDevices map[string]string
+	var errs error
 
 	p.logger.Info("began detecting resource information")
 
-	for _, detector := range p.detectors {
-		r, schemaURL, err := detector.Detect(ctx)
+	results := make([]resourceResult, len(p.detectors))
+	var wg sync.WaitGroup
+	for i, detector := range p.detectors {
+		wg.Add(1)
+		go func(i int, detector Detector) {
+			defer wg.Done()
+			results[i] = p.runDetector(ctx, detector)
+		}(i, detector)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		var partial *PartialResultError
+		switch {
+		case result.err == nil:
+		case errors.As(result.err, &partial):
+			// Merge what was detected. Only failures that may go away on their own count
+			// as errors, so that missing permissions do not cause endless retries.
+			p.logger.Warn("resource detected partially", zap.Error(partial))
+			if partial.Retryable() {
+				errs = multierr.Append(errs, partial)
+			}
+		default:
+			p.logger.Warn("failed to detect resource", zap.Error(result.err))
+			errs = multierr.Append(errs, result.err)
+			continue
+		}
+
+		conflicts, err := MergeResource(res, result.resource, p.mergePolicy)
 		if err != nil {
-			p.logger.Warn("failed to detect resource", zap.Error(err))
-		} else {
-			mergedSchemaURL = MergeSchemaURL(mergedSchemaURL, schemaURL)
-			MergeResource(res, r)
+			p.logger.Warn("failed to merge detected resource", zap.Error(err))
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if len(conflicts) > 0 {
+			p.logger.Warn("detectors returned conflicting resource attributes",
+				zap.Strings("keys", conflicts), zap.String("merge_policy", string(p.mergePolicy)))
+		}
+		mergedSchemaURL = MergeSchemaURL(mergedSchemaURL, result.schemaURL)
+		for device, volumeID := range result.volumeDevices {
+			if volumeDevices == nil {
+				volumeDevices = make(map[string]string)
+			}
+			volumeDevices[device] = volumeID
 		}
 	}
 
-	p.detectedResource.resource = res
-	p.detectedResource.schemaURL = mergedSchemaURL
+	return &resourceResult{resource: res, schemaURL: mergedSchemaURL, volumeDevices: volumeDevices}, errs
+}
+
+// runDetector runs detector within its own timeout, if one is configured, or else the
+// provider timeout.
+func (p *ResourceProvider) runDetector(ctx context.Context, detector Detector) resourceResult {
+	timeout := p.timeout
+	if td, ok := detector.(*timeoutDetector); ok {
+		timeout = td.timeout
+		detector = td.detector
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r, schemaURL, err := detector.Detect(ctx)
+	result := resourceResult{resource: r, schemaURL: schemaURL, err: err}
+	if mapper, ok := detector.(VolumeDeviceMapper); ok {
+		result.volumeDevices = mapper.VolumeDevices()
+	}
+	return result
 }
 
 func AttributesToMap(am pcommon.Map) map[string]interface{} {
@@ -169,16 +525,42 @@ func MergeSchemaURL(currentSchemaURL string, newSchemaURL string) string {
 	return currentSchemaURL
 }
 
-func MergeResource(to, from pcommon.Resource) {
+// MergeResource merges the attributes of from into to according to policy and returns
+// the keys that already existed in to with a different value. Under
+// MergePolicyFailOnConflict nothing is merged if there is any conflict.
+func MergeResource(to, from pcommon.Resource, policy MergePolicy) ([]string, error) {
 	if IsEmptyResource(from) {
-		return
+		return nil, nil
 	}
 
 	toAttr := to.Attributes()
+	conflicts := Conflicts(to, from)
+	if len(conflicts) > 0 && policy == MergePolicyFailOnConflict {
+		return conflicts, &ConflictError{Keys: conflicts}
+	}
+
 	from.Attributes().Range(func(k string, v pcommon.Value) bool {
-		toAttr.Upsert(k, v)
+		if policy == MergePolicyPreserve {
+			toAttr.Insert(k, v)
+		} else {
+			toAttr.Upsert(k, v)
+		}
+		return true
+	})
+	return conflicts, nil
+}
+
+// Conflicts returns the keys of from that are set on to with a different value.
+func Conflicts(to, from pcommon.Resource) []string {
+	toAttr := to.Attributes()
+	var conflicts []string
+	from.Attributes().Range(func(k string, v pcommon.Value) bool {
+		if existing, ok := toAttr.Get(k); ok && !existing.Equal(v) {
+			conflicts = append(conflicts, k)
+		}
 		return true
 	})
+	return conflicts
 }
 
 func IsEmptyResource(res pcommon.Resource) bool {
@@ -0,0 +1,383 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+)
+
+func newResource(attrs map[string]string) pcommon.Resource {
+	res := pcommon.NewResource()
+	for k, v := range attrs {
+		res.Attributes().InsertString(k, v)
+	}
+	return res
+}
+
+func TestMergeResource(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        MergePolicy
+		want          map[string]interface{}
+		wantConflicts []string
+		wantErr       bool
+	}{
+		{
+			name:          "override",
+			policy:        MergePolicyOverride,
+			want:          map[string]interface{}{"InstanceId": "i-detected", "host": "a", "Name": "web"},
+			wantConflicts: []string{"InstanceId"},
+		},
+		{
+			name:          "preserve",
+			policy:        MergePolicyPreserve,
+			want:          map[string]interface{}{"InstanceId": "i-upstream", "host": "a", "Name": "web"},
+			wantConflicts: []string{"InstanceId"},
+		},
+		{
+			name:          "fail on conflict",
+			policy:        MergePolicyFailOnConflict,
+			want:          map[string]interface{}{"InstanceId": "i-upstream", "host": "a"},
+			wantConflicts: []string{"InstanceId"},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := newResource(map[string]string{"InstanceId": "i-upstream", "host": "a"})
+			from := newResource(map[string]string{"InstanceId": "i-detected", "Name": "web"})
+
+			conflicts, err := MergeResource(to, from, tt.policy)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantConflicts, conflicts)
+			assert.Equal(t, tt.want, AttributesToMap(to.Attributes()))
+		})
+	}
+}
+
+func TestMergeResource_sameValueIsNotAConflict(t *testing.T) {
+	to := newResource(map[string]string{"InstanceId": "i-1"})
+	conflicts, err := MergeResource(to, newResource(map[string]string{"InstanceId": "i-1"}), MergePolicyFailOnConflict)
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
+
+type flakyDetector struct {
+	failures int32
+	calls    atomic.Int32
+}
+
+func (d *flakyDetector) Detect(context.Context) (pcommon.Resource, string, error) {
+	if d.calls.Add(1) <= d.failures {
+		return pcommon.NewResource(), "", errors.New("metadata service unavailable")
+	}
+	return newResource(map[string]string{"InstanceId": "i-1"}), "", nil
+}
+
+func TestResourceProvider_retriesFailedDetection(t *testing.T) {
+	detector := &flakyDetector{failures: 2}
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{
+		Timeout: time.Second,
+		Retry:   RetrySettings{Enabled: true, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond},
+	}, detector)
+
+	res, _, err := provider.Get(context.Background(), &http.Client{})
+	assert.EqualError(t, err, "metadata service unavailable")
+	assert.True(t, IsEmptyResource(res))
+
+	assert.Eventually(t, func() bool {
+		res, _ := provider.Current()
+		return !IsEmptyResource(res)
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	assert.EqualValues(t, 3, detector.calls.Load())
+}
+
+type identifiedDetector struct {
+	flakyDetector
+	instanceID string
+}
+
+func (d *identifiedDetector) InstanceID(context.Context) (string, error) {
+	return d.instanceID, nil
+}
+
+func TestResourceProvider_fallsBackToCache(t *testing.T) {
+	settings := ProviderSettings{
+		Timeout: time.Second,
+		Cache:   CacheSettings{Path: filepath.Join(t.TempDir(), "resource.json")},
+	}
+	client := &http.Client{}
+
+	healthy := NewResourceProvider(zap.NewNop(), settings, &identifiedDetector{instanceID: "i-1"})
+	_, _, err := healthy.Get(context.Background(), client)
+	require.NoError(t, err)
+
+	failing := NewResourceProvider(zap.NewNop(), settings, &identifiedDetector{flakyDetector: flakyDetector{failures: 1}, instanceID: "i-1"})
+	res, _, err := failing.Get(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1"}, AttributesToMap(res.Attributes()))
+
+	// Detection is still retried behind the cached resource.
+	retried := NewResourceProvider(zap.NewNop(), ProviderSettings{
+		Timeout: settings.Timeout,
+		Cache:   settings.Cache,
+		Retry:   RetrySettings{Enabled: true, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond},
+	}, &identifiedDetector{flakyDetector: flakyDetector{failures: 1}, instanceID: "i-1"})
+	_, _, err = retried.Get(context.Background(), client)
+	require.NoError(t, err)
+	detector := retried.detectors[0].(*identifiedDetector)
+	assert.Eventually(t, func() bool { return detector.calls.Load() == 2 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, retried.Shutdown(context.Background()))
+
+	replaced := NewResourceProvider(zap.NewNop(), settings, &identifiedDetector{flakyDetector: flakyDetector{failures: 1}, instanceID: "i-2"})
+	res, _, err = replaced.Get(context.Background(), client)
+	assert.Error(t, err)
+	assert.True(t, IsEmptyResource(res))
+}
+
+// sequenceDetector returns a new value of "Name" on every call, or fails once its
+// failing flag is set.
+type sequenceDetector struct {
+	calls   atomic.Int32
+	failing atomic.Bool
+}
+
+func (d *sequenceDetector) Detect(context.Context) (pcommon.Resource, string, error) {
+	n := d.calls.Add(1)
+	if d.failing.Load() {
+		return pcommon.NewResource(), "", errors.New("metadata service unavailable")
+	}
+	return newResource(map[string]string{"Name": fmt.Sprintf("web-%d", n)}), "", nil
+}
+
+func currentName(p *ResourceProvider) string {
+	res, _ := p.Current()
+	name, _ := res.Attributes().Get("Name")
+	return name.StringVal()
+}
+
+func TestResourceProvider_currentBeforeGet(t *testing.T) {
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{Timeout: time.Second}, &sequenceDetector{})
+	res, schemaURL := provider.Current()
+	assert.True(t, IsEmptyResource(res))
+	assert.Empty(t, schemaURL)
+	assert.Nil(t, provider.VolumeDevices())
+}
+
+func TestResourceProvider_refresh(t *testing.T) {
+	detector := &sequenceDetector{}
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{
+		Timeout:         time.Second,
+		RefreshInterval: time.Millisecond,
+	}, detector)
+
+	res, _, err := provider.Get(context.Background(), &http.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"Name": "web-1"}, AttributesToMap(res.Attributes()))
+
+	assert.Eventually(t, func() bool {
+		return currentName(provider) != "web-1"
+	}, 5*time.Second, time.Millisecond, "refresh should replace the detected resource")
+
+	// A failed refresh keeps the previous result. Refreshes run one at a time, so once
+	// a second call starts after failing is set, no successful refresh is in flight.
+	detector.failing.Store(true)
+	calls := detector.calls.Load()
+	assert.Eventually(t, func() bool {
+		return detector.calls.Load() > calls+1
+	}, 5*time.Second, time.Millisecond)
+	name := currentName(provider)
+	calls = detector.calls.Load()
+	assert.Eventually(t, func() bool {
+		return detector.calls.Load() > calls+2
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, name, currentName(provider))
+
+	require.NoError(t, provider.Shutdown(context.Background()))
+	calls = detector.calls.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, calls, detector.calls.Load(), "refresh should stop on shutdown")
+}
+
+func TestResourceProvider_shutdown(t *testing.T) {
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{
+		Timeout:         time.Second,
+		RefreshInterval: time.Hour,
+	}, &sequenceDetector{})
+
+	_, _, err := provider.Get(context.Background(), &http.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, "web-1", currentName(provider))
+
+	require.NoError(t, provider.Shutdown(context.Background()))
+	require.NoError(t, provider.Shutdown(context.Background()))
+	res, _ := provider.Current()
+	assert.True(t, IsEmptyResource(res))
+}
+
+// closingDetector counts how often it is closed.
+type closingDetector struct {
+	sequenceDetector
+	closed int
+}
+
+func (d *closingDetector) Close() error {
+	d.closed++
+	return nil
+}
+
+func TestResourceProvider_shutdownClosesDetectors(t *testing.T) {
+	detector := &closingDetector{}
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{Timeout: time.Second},
+		&timeoutDetector{detector: detector, timeout: time.Second})
+
+	_, _, err := provider.Get(context.Background(), &http.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, detector.closed)
+	require.NoError(t, provider.Shutdown(context.Background()))
+	assert.Equal(t, 1, detector.closed)
+}
+
+// rendezvousDetector only returns once every detector sharing its wait group has
+// started, which never happens if detectors run one after another.
+type rendezvousDetector struct {
+	started *sync.WaitGroup
+	attrs   map[string]string
+}
+
+func (d *rendezvousDetector) Detect(ctx context.Context) (pcommon.Resource, string, error) {
+	d.started.Done()
+	allStarted := make(chan struct{})
+	go func() {
+		d.started.Wait()
+		close(allStarted)
+	}()
+	select {
+	case <-allStarted:
+		return newResource(d.attrs), "", nil
+	case <-ctx.Done():
+		return pcommon.NewResource(), "", ctx.Err()
+	}
+}
+
+func TestResourceProvider_detectsConcurrently(t *testing.T) {
+	started := &sync.WaitGroup{}
+	started.Add(2)
+	provider := NewResourceProvider(zap.NewNop(), ProviderSettings{Timeout: 5 * time.Second},
+		&rendezvousDetector{started: started, attrs: map[string]string{"InstanceId": "i-1"}},
+		&rendezvousDetector{started: started, attrs: map[string]string{"Name": "web"}},
+	)
+
+	res, _, err := provider.Get(context.Background(), &http.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1", "Name": "web"}, AttributesToMap(res.Attributes()))
+}
+
+// slowDetector returns its attributes after a delay, or fails once ctx is done.
+type slowDetector struct {
+	delay time.Duration
+	attrs map[string]string
+}
+
+func (d *slowDetector) Detect(ctx context.Context) (pcommon.Resource, string, error) {
+	select {
+	case <-time.After(d.delay):
+		return newResource(d.attrs), "", nil
+	case <-ctx.Done():
+		return pcommon.NewResource(), "", ctx.Err()
+	}
+}
+
+func TestResourceProvider_mergesInDetectorOrder(t *testing.T) {
+	tests := []struct {
+		policy MergePolicy
+		want   string
+	}{
+		{policy: MergePolicyOverride, want: "second"},
+		{policy: MergePolicyPreserve, want: "first"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// The first detector finishes last; the listed order still decides the merge.
+			provider := NewResourceProvider(zap.NewNop(), ProviderSettings{Timeout: 5 * time.Second, MergePolicy: tt.policy},
+				&slowDetector{delay: 20 * time.Millisecond, attrs: map[string]string{"Name": "first"}},
+				&slowDetector{attrs: map[string]string{"Name": "second"}},
+			)
+
+			res, _, err := provider.Get(context.Background(), &http.Client{})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"Name": tt.want}, AttributesToMap(res.Attributes()))
+		})
+	}
+}
+
+type nopDetectorConfigs struct{}
+
+func (nopDetectorConfigs) GetConfigFromType(DetectorType) DetectorConfig { return nil }
+
+func TestResourceProviderFactory_detectorTimeouts(t *testing.T) {
+	detectors := map[DetectorType]Detector{
+		"slow": &slowDetector{delay: time.Minute, attrs: map[string]string{"Name": "web"}},
+		"fast": &slowDetector{attrs: map[string]string{"InstanceId": "i-1"}},
+	}
+	factories := make(map[DetectorType]DetectorFactory, len(detectors))
+	for detectorType, detector := range detectors {
+		detector := detector
+		factories[detectorType] = func(component.ProcessorCreateSettings, DetectorConfig) (Detector, error) {
+			return detector, nil
+		}
+	}
+
+	provider, err := NewProviderFactory(factories).CreateResourceProvider(componenttest.NewNopProcessorCreateSettings(),
+		ProviderSettings{
+			Timeout:          5 * time.Second,
+			DetectorTimeouts: map[DetectorType]time.Duration{"slow": 10 * time.Millisecond},
+		}, nopDetectorConfigs{}, "slow", "fast")
+	require.NoError(t, err)
+	assert.IsType(t, &timeoutDetector{}, provider.detectors[0])
+	assert.Same(t, detectors["fast"], provider.detectors[1])
+
+	start := time.Now()
+	res, _, err := provider.Get(context.Background(), &http.Client{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "the slow detector should be cut short by its own timeout")
+	// The fast detector's result is kept.
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1"}, AttributesToMap(res.Attributes()))
+}
+
+func TestResourceProviderFactory_longerDetectorTimeout(t *testing.T) {
+	factories := map[DetectorType]DetectorFactory{
+		"slow": func(component.ProcessorCreateSettings, DetectorConfig) (Detector, error) {
+			return &slowDetector{delay: 60 * time.Millisecond, attrs: map[string]string{"Name": "web"}}, nil
+		},
+	}
+
+	provider, err := NewProviderFactory(factories).CreateResourceProvider(componenttest.NewNopProcessorCreateSettings(),
+		ProviderSettings{
+			Timeout:          20 * time.Millisecond,
+			DetectorTimeouts: map[DetectorType]time.Duration{"slow": 5 * time.Second},
+		}, nopDetectorConfigs{}, "slow")
+	require.NoError(t, err)
+
+	res, _, err := provider.Get(context.Background(), &http.Client{})
+	require.NoError(t, err, "the detector's own timeout should replace the shorter provider timeout")
+	assert.Equal(t, map[string]interface{}{"Name": "web"}, AttributesToMap(res.Attributes()))
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// RetrySettings configures retrying a failed detection with exponential backoff.
+type RetrySettings struct {
+	// Enabled turns retrying on.
+	Enabled bool `mapstructure:"enabled"`
+	// InitialInterval is the time to wait before the first retry.
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+	// MaxInterval caps the time between consecutive retries.
+	MaxInterval time.Duration `mapstructure:"max_interval"`
+	// MaxElapsedTime is how long to keep retrying. Zero retries until shutdown.
+	MaxElapsedTime time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// NewDefaultRetrySettings returns the default retry settings.
+func NewDefaultRetrySettings() RetrySettings {
+	return RetrySettings{
+		Enabled:         true,
+		InitialInterval: 5 * time.Second,
+		MaxInterval:     5 * time.Minute,
+		MaxElapsedTime:  0,
+	}
+}
+
+// Validate checks that the retry intervals are consistent.
+func (s RetrySettings) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.InitialInterval <= 0 {
+		return fmt.Errorf("initial_interval must be positive, got %v", s.InitialInterval)
+	}
+	if s.MaxInterval < s.InitialInterval {
+		return fmt.Errorf("max_interval (%v) must not be less than initial_interval (%v)", s.MaxInterval, s.InitialInterval)
+	}
+	if s.MaxElapsedTime < 0 {
+		return fmt.Errorf("max_elapsed_time must not be negative, got %v", s.MaxElapsedTime)
+	}
+	return nil
+}
+
+func (s RetrySettings) newBackOff() *backoff.ExponentialBackOff {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval = s.InitialInterval
+	expBackoff.MaxInterval = s.MaxInterval
+	expBackoff.MaxElapsedTime = s.MaxElapsedTime
+	// RandomizationFactor keeps its default of 0.5 so that a fleet does not retry in lockstep.
+	expBackoff.Reset()
+	return expBackoff
+}
@@ -2,39 +2,272 @@ package taggerprocessor
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/confighttp"
 	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+
 	"poc/processor/taggerprocessor/internal"
 )
 
 type resourceDetectionProcessor struct {
-	provider           *internal.ResourceProvider
-	resource           pcommon.Resource
-	schemaURL          string
-	httpClientSettings confighttp.HTTPClientSettings
-	telemetrySettings  component.TelemetrySettings
+	provider             *internal.ResourceProvider
+	release              func(context.Context) error
+	releaseOnce          sync.Once
+	dataPointAttributes  []string
+	logRecordAttributes  []string
+	mergePolicy          internal.MergePolicy
+	failOnDetectionError bool
+	httpClientSettings   confighttp.HTTPClientSettings
+	telemetrySettings    component.TelemetrySettings
+	logger               *zap.Logger
+
+	// conflicts counts attributes that were already set upstream with a different value.
+	conflicts atomic.Uint64
 }
 
-// Start is invoked during service startup.
+// Start is invoked during service startup. A failed detection only fails the startup
+// if fail_on_detection_error is set; otherwise data passes through unenriched until a
+// retry or refresh succeeds.
 func (rdp *resourceDetectionProcessor) Start(ctx context.Context, host component.Host) error {
-	client, _ := rdp.httpClientSettings.ToClient(host, rdp.telemetrySettings)
+	client, err := rdp.httpClientSettings.ToClient(host, rdp.telemetrySettings)
+	if err != nil {
+		return fmt.Errorf("failed to create the HTTP client for resource detection: %w", err)
+	}
 	ctx = internal.ContextWithClient(ctx, client)
+	if _, _, err = rdp.provider.Get(ctx, client); err != nil {
+		if rdp.failOnDetectionError {
+			return fmt.Errorf("failed to detect resource information: %w", err)
+		}
+		rdp.logger.Warn("failed to detect resource information, continuing without it", zap.Error(err))
+	}
+	return nil
+}
+
+// Shutdown is invoked during service shutdown. It releases this processor's reference
+// to the shared provider, which stops once every processor using it has shut down.
+func (rdp *resourceDetectionProcessor) Shutdown(ctx context.Context) error {
 	var err error
-	rdp.resource, rdp.schemaURL, err = rdp.provider.Get(ctx, client)
+	rdp.releaseOnce.Do(func() {
+		err = rdp.release(ctx)
+	})
 	return err
 }
 
 // processMetrics implements the ProcessMetricsFunc type.
 func (rdp *resourceDetectionProcessor) processMetrics(_ context.Context, md pmetric.Metrics) (pmetric.Metrics, error) {
+	resource, schemaURL := rdp.provider.Current()
 	resourceMetricsSlice := md.ResourceMetrics()
+	resources := make([]pcommon.Resource, resourceMetricsSlice.Len())
+	for i := range resources {
+		resources[i] = resourceMetricsSlice.At(i).Resource()
+	}
+	if err := rdp.mergeResources(resources, resource); err != nil {
+		return md, err
+	}
 	for i := 0; i < resourceMetricsSlice.Len(); i++ {
 		rm := resourceMetricsSlice.At(i)
-		rm.SetSchemaUrl(internal.MergeSchemaURL(rm.SchemaUrl(), rdp.schemaURL))
-		res := rm.Resource()
-		internal.MergeResource(res, rdp.resource)
+		rm.SetSchemaUrl(internal.MergeSchemaURL(rm.SchemaUrl(), schemaURL))
+	}
+
+	if volumeDevices := rdp.provider.VolumeDevices(); len(volumeDevices) > 0 {
+		for i := 0; i < resourceMetricsSlice.Len(); i++ {
+			scopeMetricsSlice := resourceMetricsSlice.At(i).ScopeMetrics()
+			for j := 0; j < scopeMetricsSlice.Len(); j++ {
+				addEBSVolumeIDs(scopeMetricsSlice.At(j).Metrics(), volumeDevices)
+			}
+		}
+	}
+
+	if len(rdp.dataPointAttributes) == 0 {
+		return md, nil
+	}
+	attrs := selectAttributes(resource, rdp.dataPointAttributes)
+	if attrs.Len() == 0 {
+		return md, nil
+	}
+	for i := 0; i < resourceMetricsSlice.Len(); i++ {
+		scopeMetricsSlice := resourceMetricsSlice.At(i).ScopeMetrics()
+		for j := 0; j < scopeMetricsSlice.Len(); j++ {
+			rdp.copyToDataPoints(scopeMetricsSlice.At(j).Metrics(), attrs)
+		}
 	}
 	return md, nil
 }
+
+// processTraces implements the ProcessTracesFunc type.
+func (rdp *resourceDetectionProcessor) processTraces(_ context.Context, td ptrace.Traces) (ptrace.Traces, error) {
+	resource, schemaURL := rdp.provider.Current()
+	resourceSpansSlice := td.ResourceSpans()
+	resources := make([]pcommon.Resource, resourceSpansSlice.Len())
+	for i := range resources {
+		resources[i] = resourceSpansSlice.At(i).Resource()
+	}
+	if err := rdp.mergeResources(resources, resource); err != nil {
+		return td, err
+	}
+	for i := 0; i < resourceSpansSlice.Len(); i++ {
+		rs := resourceSpansSlice.At(i)
+		rs.SetSchemaUrl(internal.MergeSchemaURL(rs.SchemaUrl(), schemaURL))
+	}
+	return td, nil
+}
+
+// processLogs implements the ProcessLogsFunc type.
+func (rdp *resourceDetectionProcessor) processLogs(_ context.Context, ld plog.Logs) (plog.Logs, error) {
+	resource, schemaURL := rdp.provider.Current()
+	resourceLogsSlice := ld.ResourceLogs()
+	resources := make([]pcommon.Resource, resourceLogsSlice.Len())
+	for i := range resources {
+		resources[i] = resourceLogsSlice.At(i).Resource()
+	}
+	if err := rdp.mergeResources(resources, resource); err != nil {
+		return ld, err
+	}
+	for i := 0; i < resourceLogsSlice.Len(); i++ {
+		rl := resourceLogsSlice.At(i)
+		rl.SetSchemaUrl(internal.MergeSchemaURL(rl.SchemaUrl(), schemaURL))
+	}
+
+	if len(rdp.logRecordAttributes) == 0 {
+		return ld, nil
+	}
+	attrs := selectAttributes(resource, rdp.logRecordAttributes)
+	if attrs.Len() == 0 {
+		return ld, nil
+	}
+	for i := 0; i < resourceLogsSlice.Len(); i++ {
+		scopeLogsSlice := resourceLogsSlice.At(i).ScopeLogs()
+		for j := 0; j < scopeLogsSlice.Len(); j++ {
+			logRecords := scopeLogsSlice.At(j).LogRecords()
+			for k := 0; k < logRecords.Len(); k++ {
+				rdp.copyAttributes(logRecords.At(k).Attributes(), attrs)
+			}
+		}
+	}
+	return ld, nil
+}
+
+// selectAttributes returns the attributes of resource whose keys are listed in keys,
+// or all of them if keys contains "*".
+func selectAttributes(resource pcommon.Resource, keys []string) pcommon.Map {
+	selected := pcommon.NewMap()
+	for _, key := range keys {
+		if key == "*" {
+			resource.Attributes().CopyTo(selected)
+			return selected
+		}
+	}
+	for _, key := range keys {
+		if v, ok := resource.Attributes().Get(key); ok {
+			selected.Upsert(key, v)
+		}
+	}
+	return selected
+}
+
+// mergeResources merges the detected resource into every resource of a batch according
+// to the merge policy, counting and logging attributes that were already set upstream.
+// Under fail_on_conflict every resource is checked first, so that a conflict anywhere
+// rejects the batch before any of it is changed.
+func (rdp *resourceDetectionProcessor) mergeResources(resources []pcommon.Resource, detected pcommon.Resource) error {
+	if rdp.mergePolicy == internal.MergePolicyFailOnConflict {
+		var conflicts, keys []string
+		seen := make(map[string]bool)
+		for _, res := range resources {
+			for _, key := range internal.Conflicts(res, detected) {
+				conflicts = append(conflicts, key)
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+		if len(conflicts) > 0 {
+			rdp.recordConflicts(conflicts)
+			return &internal.ConflictError{Keys: keys}
+		}
+	}
+	for _, res := range resources {
+		conflicts, err := internal.MergeResource(res, detected, rdp.mergePolicy)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			rdp.recordConflicts(conflicts)
+		}
+	}
+	return nil
+}
+
+// recordConflicts counts and logs attributes that were already set upstream.
+func (rdp *resourceDetectionProcessor) recordConflicts(conflicts []string) {
+	total := rdp.conflicts.Add(uint64(len(conflicts)))
+	fields := []zap.Field{
+		zap.Strings("keys", conflicts),
+		zap.String("merge_policy", string(rdp.mergePolicy)),
+		zap.Uint64("total_conflicts", total),
+	}
+	// Warn on the first conflict only; the same upstream attributes usually conflict on every batch.
+	if total == uint64(len(conflicts)) {
+		rdp.logger.Warn("detected attributes conflict with existing resource attributes", fields...)
+	} else {
+		rdp.logger.Debug("detected attributes conflict with existing resource attributes", fields...)
+	}
+}
+
+// copyToDataPoints copies attrs onto every data point of every metric in metrics.
+func (rdp *resourceDetectionProcessor) copyToDataPoints(metrics pmetric.MetricSlice, attrs pcommon.Map) {
+	for i := 0; i < metrics.Len(); i++ {
+		metric := metrics.At(i)
+		switch metric.DataType() {
+		case pmetric.MetricDataTypeGauge:
+			dps := metric.Gauge().DataPoints()
+			for j := 0; j < dps.Len(); j++ {
+				rdp.copyAttributes(dps.At(j).Attributes(), attrs)
+			}
+		case pmetric.MetricDataTypeSum:
+			dps := metric.Sum().DataPoints()
+			for j := 0; j < dps.Len(); j++ {
+				rdp.copyAttributes(dps.At(j).Attributes(), attrs)
+			}
+		case pmetric.MetricDataTypeHistogram:
+			dps := metric.Histogram().DataPoints()
+			for j := 0; j < dps.Len(); j++ {
+				rdp.copyAttributes(dps.At(j).Attributes(), attrs)
+			}
+		case pmetric.MetricDataTypeExponentialHistogram:
+			dps := metric.ExponentialHistogram().DataPoints()
+			for j := 0; j < dps.Len(); j++ {
+				rdp.copyAttributes(dps.At(j).Attributes(), attrs)
+			}
+		case pmetric.MetricDataTypeSummary:
+			dps := metric.Summary().DataPoints()
+			for j := 0; j < dps.Len(); j++ {
+				rdp.copyAttributes(dps.At(j).Attributes(), attrs)
+			}
+		}
+	}
+}
+
+// copyAttributes copies from into to. Existing values are only replaced under the
+// override merge policy. Under fail_on_conflict they are kept as under preserve: the
+// batch was already checked for conflicts at the resource level, and data point and
+// log record attributes set upstream are not treated as conflicts.
+func (rdp *resourceDetectionProcessor) copyAttributes(to, from pcommon.Map) {
+	from.Range(func(k string, v pcommon.Value) bool {
+		if rdp.mergePolicy == "" || rdp.mergePolicy == internal.MergePolicyOverride {
+			to.Upsert(k, v)
+		} else {
+			to.Insert(k, v)
+		}
+		return true
+	})
+}
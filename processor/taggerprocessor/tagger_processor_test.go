@@ -0,0 +1,342 @@
+package taggerprocessor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"poc/processor/taggerprocessor/internal"
+)
+
+const staticDetectorType = "static"
+
+// staticDetector detects a fixed set of attributes.
+type staticDetector struct {
+	attrs map[string]interface{}
+}
+
+func (d *staticDetector) Detect(context.Context) (pcommon.Resource, string, error) {
+	res := pcommon.NewResource()
+	pcommon.NewMapFromRaw(d.attrs).CopyTo(res.Attributes())
+	return res, "", nil
+}
+
+// newTestFactory returns a factory whose only detector is a staticDetector detecting attrs.
+func newTestFactory(attrs map[string]interface{}) (*factory, *Config) {
+	return newDetectorFactory(&staticDetector{attrs: attrs})
+}
+
+// newDetectorFactory returns a factory whose only detector is detector.
+func newDetectorFactory(detector internal.Detector) (*factory, *Config) {
+	f := &factory{
+		resourceProviderFactory: internal.NewProviderFactory(map[internal.DetectorType]internal.DetectorFactory{
+			staticDetectorType: func(component.ProcessorCreateSettings, internal.DetectorConfig) (internal.Detector, error) {
+				return detector, nil
+			},
+		}),
+		providers: map[config.ComponentID]*sharedProvider{},
+	}
+	cfg := createDefaultConfig().(*Config)
+	cfg.Detectors = []string{staticDetectorType}
+	return f, cfg
+}
+
+// newTestProcessor returns a started processor detecting attrs and configured by cfg.
+func newTestProcessor(t *testing.T, f *factory, cfg *Config) *resourceDetectionProcessor {
+	rdp, err := f.getResourceDetectionProcessor(componenttest.NewNopProcessorCreateSettings(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, rdp.Start(context.Background(), componenttest.NewNopHost()))
+	t.Cleanup(func() { assert.NoError(t, rdp.Shutdown(context.Background())) })
+	return rdp
+}
+
+func TestProcessor_shutdownKeepsSharedProvider(t *testing.T) {
+	f, cfg := newTestFactory(map[string]interface{}{"InstanceId": "i-1"})
+	params := componenttest.NewNopProcessorCreateSettings()
+
+	mp, err := f.createMetricsProcessor(context.Background(), params, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	sink := &consumertest.TracesSink{}
+	tp, err := f.createTracesProcessor(context.Background(), params, cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, mp.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, tp.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Shutting down the metrics pipeline must not stop detection for the traces pipeline.
+	require.NoError(t, mp.Shutdown(context.Background()))
+	td := ptrace.NewTraces()
+	td.ResourceSpans().AppendEmpty()
+	require.NoError(t, tp.ConsumeTraces(context.Background(), td))
+	require.Len(t, sink.AllTraces(), 1)
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1"},
+		sink.AllTraces()[0].ResourceSpans().At(0).Resource().Attributes().AsRaw())
+
+	require.NoError(t, tp.Shutdown(context.Background()))
+}
+
+func TestSelectAttributes(t *testing.T) {
+	res := pcommon.NewResource()
+	pcommon.NewMapFromRaw(map[string]interface{}{"InstanceId": "i-1", "Name": "web"}).CopyTo(res.Attributes())
+
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1"},
+		selectAttributes(res, []string{"InstanceId", "Missing"}).AsRaw())
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-1", "Name": "web"},
+		selectAttributes(res, []string{"*"}).AsRaw())
+	assert.Equal(t, 0, selectAttributes(res, []string{"Missing"}).Len())
+}
+
+// newAllTypesMetrics returns one metric of every data type, each with one data point.
+func newAllTypesMetrics() pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	metrics := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+
+	gauge := metrics.AppendEmpty()
+	gauge.SetDataType(pmetric.MetricDataTypeGauge)
+	gauge.Gauge().DataPoints().AppendEmpty()
+	sum := metrics.AppendEmpty()
+	sum.SetDataType(pmetric.MetricDataTypeSum)
+	sum.Sum().DataPoints().AppendEmpty()
+	histogram := metrics.AppendEmpty()
+	histogram.SetDataType(pmetric.MetricDataTypeHistogram)
+	histogram.Histogram().DataPoints().AppendEmpty()
+	expHistogram := metrics.AppendEmpty()
+	expHistogram.SetDataType(pmetric.MetricDataTypeExponentialHistogram)
+	expHistogram.ExponentialHistogram().DataPoints().AppendEmpty()
+	summary := metrics.AppendEmpty()
+	summary.SetDataType(pmetric.MetricDataTypeSummary)
+	summary.Summary().DataPoints().AppendEmpty()
+	return md
+}
+
+// dataPointAttributes returns the attributes of the only data point of each metric,
+// keyed by data type.
+func dataPointAttributes(md pmetric.Metrics) map[pmetric.MetricDataType]map[string]interface{} {
+	got := make(map[pmetric.MetricDataType]map[string]interface{})
+	metrics := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	for i := 0; i < metrics.Len(); i++ {
+		metric := metrics.At(i)
+		var attrs pcommon.Map
+		switch metric.DataType() {
+		case pmetric.MetricDataTypeGauge:
+			attrs = metric.Gauge().DataPoints().At(0).Attributes()
+		case pmetric.MetricDataTypeSum:
+			attrs = metric.Sum().DataPoints().At(0).Attributes()
+		case pmetric.MetricDataTypeHistogram:
+			attrs = metric.Histogram().DataPoints().At(0).Attributes()
+		case pmetric.MetricDataTypeExponentialHistogram:
+			attrs = metric.ExponentialHistogram().DataPoints().At(0).Attributes()
+		case pmetric.MetricDataTypeSummary:
+			attrs = metric.Summary().DataPoints().At(0).Attributes()
+		}
+		got[metric.DataType()] = attrs.AsRaw()
+	}
+	return got
+}
+
+func TestProcessMetrics_dataPointAttributes(t *testing.T) {
+	detected := map[string]interface{}{"InstanceId": "i-1", "Name": "web", "Team": "core"}
+	tests := []struct {
+		name string
+		keys []string
+		want map[string]interface{}
+	}{
+		{
+			name: "selected keys",
+			keys: []string{"InstanceId", "Name"},
+			want: map[string]interface{}{"InstanceId": "i-1", "Name": "web"},
+		},
+		{
+			name: "missing key",
+			keys: []string{"InstanceId", "CostCenter"},
+			want: map[string]interface{}{"InstanceId": "i-1"},
+		},
+		{
+			name: "only missing keys",
+			keys: []string{"CostCenter"},
+			want: map[string]interface{}{},
+		},
+		{
+			name: "all",
+			keys: []string{"*"},
+			want: detected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, cfg := newTestFactory(detected)
+			cfg.DataPointAttributes = tt.keys
+			rdp := newTestProcessor(t, f, cfg)
+
+			md, err := rdp.processMetrics(context.Background(), newAllTypesMetrics())
+			require.NoError(t, err)
+			assert.Equal(t, map[pmetric.MetricDataType]map[string]interface{}{
+				pmetric.MetricDataTypeGauge:                tt.want,
+				pmetric.MetricDataTypeSum:                  tt.want,
+				pmetric.MetricDataTypeHistogram:            tt.want,
+				pmetric.MetricDataTypeExponentialHistogram: tt.want,
+				pmetric.MetricDataTypeSummary:              tt.want,
+			}, dataPointAttributes(md))
+		})
+	}
+}
+
+func TestProcessTraces(t *testing.T) {
+	f, cfg := newTestFactory(map[string]interface{}{"InstanceId": "i-1", "Name": "web"})
+	rdp := newTestProcessor(t, f, cfg)
+
+	td := ptrace.NewTraces()
+	for _, service := range []string{"frontend", "backend"} {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().InsertString("service.name", service)
+		rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty().SetName("GET /")
+	}
+
+	td, err := rdp.processTraces(context.Background(), td)
+	require.NoError(t, err)
+	require.Equal(t, 2, td.ResourceSpans().Len())
+	for i, service := range []string{"frontend", "backend"} {
+		rs := td.ResourceSpans().At(i)
+		assert.Equal(t, map[string]interface{}{"service.name": service, "InstanceId": "i-1", "Name": "web"},
+			rs.Resource().Attributes().AsRaw())
+		span := rs.ScopeSpans().At(0).Spans().At(0)
+		assert.Equal(t, "GET /", span.Name())
+		assert.Equal(t, 0, span.Attributes().Len())
+	}
+}
+
+// newTestLogs returns logs with one resource holding one log record with attrs.
+func newTestLogs(attrs map[string]interface{}) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "app")
+	lr := rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	lr.Body().SetStringVal("disk full")
+	pcommon.NewMapFromRaw(attrs).CopyTo(lr.Attributes())
+	return ld
+}
+
+func TestProcessLogs(t *testing.T) {
+	f, cfg := newTestFactory(map[string]interface{}{"InstanceId": "i-1", "Name": "web"})
+	rdp := newTestProcessor(t, f, cfg)
+
+	ld, err := rdp.processLogs(context.Background(), newTestLogs(nil))
+	require.NoError(t, err)
+	rl := ld.ResourceLogs().At(0)
+	assert.Equal(t, map[string]interface{}{"service.name": "app", "InstanceId": "i-1", "Name": "web"},
+		rl.Resource().Attributes().AsRaw())
+	lr := rl.ScopeLogs().At(0).LogRecords().At(0)
+	assert.Equal(t, "disk full", lr.Body().StringVal())
+	// Log record attributes are left alone unless log_record_attributes is set.
+	assert.Equal(t, 0, lr.Attributes().Len())
+}
+
+func TestProcessLogs_logRecordAttributes(t *testing.T) {
+	detected := map[string]interface{}{"InstanceId": "i-1", "Name": "web"}
+	tests := []struct {
+		name        string
+		keys        []string
+		mergePolicy internal.MergePolicy
+		attrs       map[string]interface{}
+		want        map[string]interface{}
+	}{
+		{
+			name: "selected keys",
+			keys: []string{"InstanceId"},
+			want: map[string]interface{}{"InstanceId": "i-1"},
+		},
+		{
+			name: "missing key",
+			keys: []string{"InstanceId", "CostCenter"},
+			want: map[string]interface{}{"InstanceId": "i-1"},
+		},
+		{
+			name: "all",
+			keys: []string{"*"},
+			want: detected,
+		},
+		{
+			name:  "override",
+			keys:  []string{"Name"},
+			attrs: map[string]interface{}{"Name": "upstream", "level": "warn"},
+			want:  map[string]interface{}{"Name": "web", "level": "warn"},
+		},
+		{
+			name:        "preserve",
+			keys:        []string{"Name"},
+			mergePolicy: internal.MergePolicyPreserve,
+			attrs:       map[string]interface{}{"Name": "upstream", "level": "warn"},
+			want:        map[string]interface{}{"Name": "upstream", "level": "warn"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, cfg := newTestFactory(detected)
+			cfg.LogRecordAttributes = tt.keys
+			if tt.mergePolicy != "" {
+				cfg.MergePolicy = tt.mergePolicy
+			}
+			rdp := newTestProcessor(t, f, cfg)
+
+			ld, err := rdp.processLogs(context.Background(), newTestLogs(tt.attrs))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0).Attributes().AsRaw())
+		})
+	}
+}
+
+func TestProcessMetrics_failOnConflictLeavesBatchUnchanged(t *testing.T) {
+	f, cfg := newTestFactory(map[string]interface{}{"InstanceId": "i-1"})
+	cfg.MergePolicy = internal.MergePolicyFailOnConflict
+	rdp := newTestProcessor(t, f, cfg)
+
+	md := pmetric.NewMetrics()
+	md.ResourceMetrics().AppendEmpty().Resource().Attributes().InsertString("service.name", "app")
+	md.ResourceMetrics().AppendEmpty().Resource().Attributes().InsertString("InstanceId", "i-pushed")
+
+	_, err := rdp.processMetrics(context.Background(), md)
+	assert.Equal(t, &internal.ConflictError{Keys: []string{"InstanceId"}}, err)
+	// The first resource does not conflict but must not be changed either.
+	assert.Equal(t, map[string]interface{}{"service.name": "app"},
+		md.ResourceMetrics().At(0).Resource().Attributes().AsRaw())
+	assert.Equal(t, map[string]interface{}{"InstanceId": "i-pushed"},
+		md.ResourceMetrics().At(1).Resource().Attributes().AsRaw())
+	assert.Equal(t, uint64(1), rdp.conflicts.Load())
+}
+
+// failingDetector always fails and counts its calls.
+type failingDetector struct {
+	calls atomic.Int32
+}
+
+func (d *failingDetector) Detect(context.Context) (pcommon.Resource, string, error) {
+	d.calls.Add(1)
+	return pcommon.NewResource(), "", errors.New("metadata service unavailable")
+}
+
+func TestProcessor_failOnDetectionErrorStartsNoBackgroundWork(t *testing.T) {
+	detector := &failingDetector{}
+	f, cfg := newDetectorFactory(detector)
+	cfg.FailOnDetectionError = true
+	cfg.RefreshInterval = time.Millisecond
+	cfg.RetryOnFailure = internal.RetrySettings{Enabled: true, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+
+	rdp, err := f.getResourceDetectionProcessor(componenttest.NewNopProcessorCreateSettings(), cfg)
+	require.NoError(t, err)
+	assert.Error(t, rdp.Start(context.Background(), componenttest.NewNopHost()))
+	time.Sleep(20 * time.Millisecond)
+	assert.EqualValues(t, 1, detector.calls.Load(), "no retry or refresh should run after a failed start")
+	assert.NoError(t, rdp.Shutdown(context.Background()))
+}
@@ -0,0 +1,37 @@
+package ec2eventsreceiver
+
+import (
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/multierr"
+)
+
+// Config defines the configuration of the EC2 events receiver.
+type Config struct {
+	config.ReceiverSettings `mapstructure:",squash"`
+
+	// PollInterval is how often instance metadata is polled for events. Spot interruption
+	// notices are given two minutes ahead, so it should stay well below that.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+
+	// RequireIMDSv2 fails metadata requests instead of falling back to IMDSv1.
+	RequireIMDSv2 bool `mapstructure:"require_imdsv2"`
+
+	// Endpoint overrides the instance metadata service endpoint.
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+var _ config.Receiver = (*Config)(nil)
+
+// Validate checks the poll interval.
+func (cfg *Config) Validate() error {
+	var errs error
+	if cfg.PollInterval <= 0 {
+		errs = multierr.Append(errs, fmt.Errorf("poll_interval: must be positive, got %v", cfg.PollInterval))
+	} else if cfg.PollInterval >= 2*time.Minute {
+		errs = multierr.Append(errs, fmt.Errorf("poll_interval: must be shorter than the 2m spot interruption notice, got %v", cfg.PollInterval))
+	}
+	return errs
+}
@@ -0,0 +1,151 @@
+package ec2eventsreceiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+const (
+	typeStr   = "ec2events"
+	stability = component.StabilityLevelAlpha
+
+	defaultPollInterval = 5 * time.Second
+)
+
+// NewFactory returns the factory of the EC2 events receiver, which reports spot
+// interruption notices and scheduled maintenance events of the instance as log records
+// or as a gauge.
+func NewFactory() component.ReceiverFactory {
+	f := &factory{receivers: map[config.ComponentID]*sharedReceiver{}}
+	return component.NewReceiverFactory(
+		typeStr,
+		createDefaultConfig,
+		component.WithLogsReceiver(f.createLogsReceiver, stability),
+		component.WithMetricsReceiver(f.createMetricsReceiver, stability))
+}
+
+type factory struct {
+	// receivers stores a receiver for each named receiver, shared by its logs and
+	// metrics pipelines so that instance metadata is polled once. It is shut down when
+	// the last of them is.
+	receivers map[config.ComponentID]*sharedReceiver
+	lock      sync.Mutex
+}
+
+// sharedReceiver counts the pipelines using a receiver.
+type sharedReceiver struct {
+	receiver *eventsReceiver
+	refs     int
+}
+
+func createDefaultConfig() config.Receiver {
+	return &Config{
+		ReceiverSettings: config.NewReceiverSettings(config.NewComponentID(typeStr)),
+		PollInterval:     defaultPollInterval,
+	}
+}
+
+func (f *factory) createLogsReceiver(
+	_ context.Context,
+	params component.ReceiverCreateSettings,
+	cfg config.Receiver,
+	nextConsumer consumer.Logs,
+) (component.LogsReceiver, error) {
+	return f.getReceiver(params, cfg, func(r *eventsReceiver) { r.logsConsumer = nextConsumer })
+}
+
+func (f *factory) createMetricsReceiver(
+	_ context.Context,
+	params component.ReceiverCreateSettings,
+	cfg config.Receiver,
+	nextConsumer consumer.Metrics,
+) (component.MetricsReceiver, error) {
+	return f.getReceiver(params, cfg, func(r *eventsReceiver) { r.metricsConsumer = nextConsumer })
+}
+
+// getReceiver returns a handle on the receiver of cfg, creating the receiver if no
+// other pipeline uses it yet, and hands it the pipeline's consumer.
+func (f *factory) getReceiver(
+	params component.ReceiverCreateSettings,
+	cfg config.Receiver,
+	setConsumer func(*eventsReceiver),
+) (*receiverHandle, error) {
+	rCfg, ok := cfg.(*Config)
+	if !ok {
+		return nil, fmt.Errorf("invalid config type %T for the %s receiver", cfg, typeStr)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	shared, ok := f.receivers[cfg.ID()]
+	if !ok {
+		r, err := newEventsReceiver(rCfg, params.Logger)
+		if err != nil {
+			return nil, err
+		}
+		shared = &sharedReceiver{receiver: r}
+		f.receivers[cfg.ID()] = shared
+	}
+	shared.refs++
+	setConsumer(shared.receiver)
+
+	return &receiverHandle{
+		receiver: shared.receiver,
+		release: func(ctx context.Context) error {
+			return f.releaseReceiver(ctx, cfg.ID())
+		},
+	}, nil
+}
+
+// releaseReceiver drops one reference to the named receiver. The last reference shuts
+// the receiver down and forgets it, so that a rebuilt pipeline gets a fresh receiver
+// with the current configuration.
+func (f *factory) releaseReceiver(ctx context.Context, receiverName config.ComponentID) error {
+	f.lock.Lock()
+	shared, ok := f.receivers[receiverName]
+	if !ok {
+		f.lock.Unlock()
+		return nil
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		f.lock.Unlock()
+		return nil
+	}
+	delete(f.receivers, receiverName)
+	f.lock.Unlock()
+
+	return shared.receiver.Shutdown(ctx)
+}
+
+// receiverHandle is the receiver of one pipeline. Starting any handle starts the
+// shared receiver.
+type receiverHandle struct {
+	receiver    *eventsReceiver
+	release     func(context.Context) error
+	releaseOnce sync.Once
+}
+
+var _ component.LogsReceiver = (*receiverHandle)(nil)
+var _ component.MetricsReceiver = (*receiverHandle)(nil)
+
+func (h *receiverHandle) Start(ctx context.Context, host component.Host) error {
+	return h.receiver.Start(ctx, host)
+}
+
+// Shutdown releases this pipeline's reference to the shared receiver, which stops once
+// every pipeline using it has shut down.
+func (h *receiverHandle) Shutdown(ctx context.Context) error {
+	var err error
+	h.releaseOnce.Do(func() {
+		err = h.release(ctx)
+	})
+	return err
+}
@@ -0,0 +1,252 @@
+package ec2eventsreceiver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	ec2provider "poc/internal/ec2metadata"
+)
+
+const (
+	eventTypeSpotInterruption     = "spot_interruption"
+	eventTypeScheduledMaintenance = "scheduled_maintenance"
+
+	// metricName is the gauge of pending events, with one data point per event type.
+	metricName = "aws.ec2.instance.events"
+
+	attributeEventType      = "aws.ec2.event.type"
+	attributeEventID        = "aws.ec2.event.id"
+	attributeEventCode      = "aws.ec2.event.code"
+	attributeEventState     = "aws.ec2.event.state"
+	attributeEventNotBefore = "aws.ec2.event.not_before"
+	attributeEventNotAfter  = "aws.ec2.event.not_after"
+	attributeSpotAction     = "aws.ec2.spot.action"
+	attributeSpotActionTime = "aws.ec2.spot.action_time"
+	scopeName               = "poc/receiver/ec2eventsreceiver"
+)
+
+// instanceEvent is a spot interruption or a scheduled maintenance event.
+type instanceEvent struct {
+	eventType  string
+	id         string
+	body       string
+	attributes map[string]string
+}
+
+// eventsReceiver polls instance metadata for events. Each new event, or event whose
+// state changed, is emitted once as a log record, while the gauge reports the number of
+// pending events of each type on every poll.
+type eventsReceiver struct {
+	metadataProvider ec2provider.Provider
+	pollInterval     time.Duration
+	logger           *zap.Logger
+
+	logsConsumer    consumer.Logs
+	metricsConsumer consumer.Metrics
+
+	seen      map[string]bool
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ component.LogsReceiver = (*eventsReceiver)(nil)
+var _ component.MetricsReceiver = (*eventsReceiver)(nil)
+
+func newEventsReceiver(cfg *Config, logger *zap.Logger) (*eventsReceiver, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	var opts []ec2provider.Option
+	if cfg.RequireIMDSv2 {
+		opts = append(opts, ec2provider.WithIMDSv2Required())
+	}
+	if cfg.Endpoint != "" {
+		opts = append(opts, ec2provider.WithEndpoint(cfg.Endpoint))
+	}
+	return &eventsReceiver{
+		metadataProvider: ec2provider.NewProvider(sess, opts...),
+		pollInterval:     cfg.PollInterval,
+		logger:           logger,
+		seen:             make(map[string]bool),
+		stopCh:           make(chan struct{}),
+	}, nil
+}
+
+// Start starts polling. Only the first call has an effect, as the receiver is shared
+// by the logs and metrics pipelines of its configuration.
+func (r *eventsReceiver) Start(_ context.Context, _ component.Host) error {
+	r.startOnce.Do(func() {
+		r.wg.Add(1)
+		go r.pollLoop()
+	})
+	return nil
+}
+
+// Shutdown stops polling and waits for the poll in progress, if any. It may be called
+// more than once.
+func (r *eventsReceiver) Shutdown(context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	r.wg.Wait()
+	return nil
+}
+
+func (r *eventsReceiver) pollLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), r.pollInterval)
+		if err := r.poll(ctx); err != nil {
+			r.logger.Warn("failed to poll instance events", zap.Error(err))
+		}
+		cancel()
+
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the current events and sends them to the next consumer. Events that could
+// be read are sent even if reading others failed.
+func (r *eventsReceiver) poll(ctx context.Context) error {
+	events, readErr := r.readEvents(ctx)
+	errs := readErr
+	now := pcommon.NewTimestampFromTime(time.Now())
+
+	if r.logsConsumer != nil {
+		logs, seen := r.newEventLogs(events, readErr == nil, now)
+		var err error
+		if logs.LogRecordCount() > 0 {
+			err = r.logsConsumer.ConsumeLogs(ctx, logs)
+		}
+		// Events the consumer did not accept are reported again on the next poll.
+		if err == nil {
+			r.seen = seen
+		}
+		errs = multierr.Append(errs, err)
+	}
+	if r.metricsConsumer != nil && readErr == nil {
+		// A partial count would look like an event went away, so none is reported.
+		errs = multierr.Append(errs, r.metricsConsumer.ConsumeMetrics(ctx, newEventMetrics(events, now)))
+	}
+	return errs
+}
+
+func (r *eventsReceiver) readEvents(ctx context.Context) ([]instanceEvent, error) {
+	var events []instanceEvent
+	var errs error
+
+	action, err := r.metadataProvider.SpotInstanceAction(ctx)
+	if err != nil {
+		errs = multierr.Append(errs, err)
+	} else if action != nil {
+		events = append(events, instanceEvent{
+			eventType: eventTypeSpotInterruption,
+			id:        action.Action + "/" + action.Time,
+			body:      "Spot instance interruption: " + action.Action + " at " + action.Time,
+			attributes: map[string]string{
+				attributeSpotAction:     action.Action,
+				attributeSpotActionTime: action.Time,
+			},
+		})
+	}
+
+	scheduled, err := r.metadataProvider.ScheduledEvents(ctx)
+	if err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	for _, event := range scheduled {
+		events = append(events, instanceEvent{
+			eventType: eventTypeScheduledMaintenance,
+			id:        event.EventID + "/" + event.State,
+			body:      "Scheduled maintenance " + event.Code + ": " + event.Description,
+			attributes: map[string]string{
+				attributeEventID:        event.EventID,
+				attributeEventCode:      event.Code,
+				attributeEventState:     event.State,
+				attributeEventNotBefore: event.NotBefore,
+				attributeEventNotAfter:  event.NotAfter,
+			},
+		})
+	}
+	return events, errs
+}
+
+// newEventLogs returns a log record for every event not reported before, and the events
+// reported once the logs are consumed. When events is complete, the events that are
+// gone are forgotten.
+func (r *eventsReceiver) newEventLogs(events []instanceEvent, complete bool, now pcommon.Timestamp) (plog.Logs, map[string]bool) {
+	logs := plog.NewLogs()
+	sl := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty()
+	sl.Scope().SetName(scopeName)
+
+	current := make(map[string]bool, len(events))
+	for _, event := range events {
+		key := event.eventType + "/" + event.id
+		current[key] = true
+		if r.seen[key] {
+			continue
+		}
+
+		lr := sl.LogRecords().AppendEmpty()
+		lr.SetTimestamp(now)
+		lr.SetObservedTimestamp(now)
+		lr.SetSeverityNumber(plog.SeverityNumberWARN)
+		lr.SetSeverityText("WARN")
+		lr.Body().SetStringVal(event.body)
+		lr.Attributes().InsertString(attributeEventType, event.eventType)
+		for key, val := range event.attributes {
+			lr.Attributes().InsertString(key, val)
+		}
+	}
+	if complete {
+		return logs, current
+	}
+	for key := range r.seen {
+		current[key] = true
+	}
+	return logs, current
+}
+
+// newEventMetrics returns the number of pending events of each type.
+func newEventMetrics(events []instanceEvent, now pcommon.Timestamp) pmetric.Metrics {
+	counts := map[string]int64{eventTypeSpotInterruption: 0, eventTypeScheduledMaintenance: 0}
+	for _, event := range events {
+		counts[event.eventType]++
+	}
+
+	metrics := pmetric.NewMetrics()
+	sm := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName(scopeName)
+	m := sm.Metrics().AppendEmpty()
+	m.SetName(metricName)
+	m.SetDescription("Number of pending spot interruptions and scheduled maintenance events of the instance.")
+	m.SetUnit("{events}")
+	m.SetDataType(pmetric.MetricDataTypeGauge)
+	for _, eventType := range []string{eventTypeSpotInterruption, eventTypeScheduledMaintenance} {
+		dp := m.Gauge().DataPoints().AppendEmpty()
+		dp.SetTimestamp(now)
+		dp.SetIntVal(counts[eventType])
+		dp.Attributes().InsertString(attributeEventType, eventType)
+	}
+	return metrics
+}
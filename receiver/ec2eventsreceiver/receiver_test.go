@@ -0,0 +1,263 @@
+package ec2eventsreceiver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/multierr"
+
+	ec2provider "poc/internal/ec2metadata"
+)
+
+// fakeIMDS serves the event paths of instance metadata from a mutable map.
+type fakeIMDS struct {
+	lock  sync.Mutex
+	paths map[string]string
+	// failing lists the paths answered with a server error.
+	failing map[string]bool
+}
+
+func (f *fakeIMDS) setFailing(path string, failing bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failing[path] = failing
+}
+
+func (f *fakeIMDS) set(path, value string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if value == "" {
+		delete(f.paths, path)
+		return
+	}
+	f.paths[path] = value
+}
+
+func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failing[r.URL.Path] {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	value, ok := f.paths[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write([]byte(value))
+}
+
+const (
+	spotPath      = "/latest/meta-data/spot/instance-action"
+	scheduledPath = "/latest/meta-data/events/maintenance/scheduled"
+)
+
+func newTestReceiver(t *testing.T) (*eventsReceiver, *fakeIMDS) {
+	imds := &fakeIMDS{paths: map[string]string{scheduledPath: "[]"}, failing: map[string]bool{}}
+	srv := httptest.NewServer(imds)
+	t.Cleanup(srv.Close)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.Endpoint = srv.URL
+	r, err := newEventsReceiver(cfg, componenttest.NewNopReceiverCreateSettings().Logger)
+	require.NoError(t, err)
+	// Failed requests are not retried, so that a server error fails the poll at once.
+	r.metadataProvider = ec2provider.NewProvider(mock.Session, ec2provider.WithEndpoint(srv.URL), ec2provider.WithMaxRetries(0))
+	return r, imds
+}
+
+const spotAction = `{"action": "terminate", "time": "2022-09-18T08:22:00Z"}`
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.PollInterval = 0
+	assert.EqualError(t, cfg.Validate(), "poll_interval: must be positive, got 0s")
+	cfg.PollInterval = 5 * time.Minute
+	assert.EqualError(t, cfg.Validate(), "poll_interval: must be shorter than the 2m spot interruption notice, got 5m0s")
+}
+
+func TestEventsReceiver_logs(t *testing.T) {
+	r, imds := newTestReceiver(t)
+	sink := &consumertest.LogsSink{}
+	r.logsConsumer = sink
+
+	require.NoError(t, r.poll(context.Background()))
+	assert.Equal(t, 0, sink.LogRecordCount())
+
+	imds.set(spotPath, spotAction)
+	imds.set(scheduledPath, `[{"NotBefore": "21 Jan 2023 09:00:43 GMT", "Code": "system-reboot",
+		"Description": "scheduled reboot", "EventId": "instance-event-0d59937288b749b32",
+		"NotAfter": "21 Jan 2023 09:17:23 GMT", "State": "active"}]`)
+	require.NoError(t, r.poll(context.Background()))
+	require.Equal(t, 2, sink.LogRecordCount())
+
+	records := sink.AllLogs()[0].ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	spot := records.At(0)
+	assert.Equal(t, "Spot instance interruption: terminate at 2022-09-18T08:22:00Z", spot.Body().StringVal())
+	assert.Equal(t, map[string]interface{}{
+		attributeEventType:      eventTypeSpotInterruption,
+		attributeSpotAction:     "terminate",
+		attributeSpotActionTime: "2022-09-18T08:22:00Z",
+	}, spot.Attributes().AsRaw())
+	eventID, _ := records.At(1).Attributes().Get(attributeEventID)
+	assert.Equal(t, "instance-event-0d59937288b749b32", eventID.StringVal())
+
+	// Events already reported are not repeated.
+	require.NoError(t, r.poll(context.Background()))
+	assert.Equal(t, 2, sink.LogRecordCount())
+}
+
+func TestEventsReceiver_metrics(t *testing.T) {
+	r, imds := newTestReceiver(t)
+	sink := &consumertest.MetricsSink{}
+	r.metricsConsumer = sink
+
+	imds.set(spotPath, `{"action": "stop", "time": "2022-09-18T08:22:00Z"}`)
+	require.NoError(t, r.poll(context.Background()))
+	imds.set(spotPath, "")
+	require.NoError(t, r.poll(context.Background()))
+
+	counts := func(md pmetric.Metrics) map[string]int64 {
+		m := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+		assert.Equal(t, metricName, m.Name())
+		got := make(map[string]int64)
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			dp := m.Gauge().DataPoints().At(i)
+			eventType, _ := dp.Attributes().Get(attributeEventType)
+			got[eventType.StringVal()] = dp.IntVal()
+		}
+		return got
+	}
+	require.Len(t, sink.AllMetrics(), 2)
+	assert.Equal(t, map[string]int64{eventTypeSpotInterruption: 1, eventTypeScheduledMaintenance: 0}, counts(sink.AllMetrics()[0]))
+	assert.Equal(t, map[string]int64{eventTypeSpotInterruption: 0, eventTypeScheduledMaintenance: 0}, counts(sink.AllMetrics()[1]))
+}
+
+func TestEventsReceiver_startShutdown(t *testing.T) {
+	r, _ := newTestReceiver(t)
+	r.metricsConsumer = consumertest.NewNop()
+	require.NoError(t, r.Start(context.Background(), componenttest.NewNopHost()))
+	require.NoError(t, r.Shutdown(context.Background()))
+	require.NoError(t, r.Shutdown(context.Background()))
+}
+
+func TestEventsReceiver_partialRead(t *testing.T) {
+	r, imds := newTestReceiver(t)
+	logs := &consumertest.LogsSink{}
+	metrics := &consumertest.MetricsSink{}
+	r.logsConsumer = logs
+	r.metricsConsumer = metrics
+
+	imds.set(spotPath, spotAction)
+	imds.setFailing(scheduledPath, true)
+	assert.Error(t, r.poll(context.Background()))
+	// The spot interruption is reported, but no count that would miss the scheduled events.
+	assert.Equal(t, 1, logs.LogRecordCount())
+	assert.Empty(t, metrics.AllMetrics())
+
+	imds.setFailing(scheduledPath, false)
+	require.NoError(t, r.poll(context.Background()))
+	assert.Equal(t, 1, logs.LogRecordCount(), "the spot interruption should not be reported twice")
+	assert.Len(t, metrics.AllMetrics(), 1)
+}
+
+func TestEventsReceiver_metadataServerError(t *testing.T) {
+	r, imds := newTestReceiver(t)
+	logs := &consumertest.LogsSink{}
+	metrics := &consumertest.MetricsSink{}
+	r.logsConsumer = logs
+	r.metricsConsumer = metrics
+
+	imds.setFailing(spotPath, true)
+	imds.setFailing(scheduledPath, true)
+	err := r.poll(context.Background())
+	assert.Len(t, multierr.Errors(err), 2)
+	assert.Equal(t, 0, logs.LogRecordCount())
+	assert.Empty(t, metrics.AllMetrics())
+}
+
+// flakyLogsSink fails the first calls, then stores the logs it is given.
+type flakyLogsSink struct {
+	consumertest.LogsSink
+	failures int
+}
+
+func (s *flakyLogsSink) ConsumeLogs(ctx context.Context, ld plog.Logs) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("queue is full")
+	}
+	return s.LogsSink.ConsumeLogs(ctx, ld)
+}
+
+func TestEventsReceiver_consumerError(t *testing.T) {
+	r, imds := newTestReceiver(t)
+	logs := &flakyLogsSink{failures: 1}
+	metrics := &consumertest.MetricsSink{}
+	r.logsConsumer = logs
+	r.metricsConsumer = metrics
+
+	imds.set(spotPath, spotAction)
+	assert.EqualError(t, r.poll(context.Background()), "queue is full")
+	// A failed logs consumer does not hold back the metrics.
+	assert.Len(t, metrics.AllMetrics(), 1)
+
+	// The event the consumer rejected is reported on the next poll, and only once.
+	require.NoError(t, r.poll(context.Background()))
+	require.NoError(t, r.poll(context.Background()))
+	assert.Equal(t, 1, logs.LogRecordCount())
+}
+
+func TestFactory_sharesReceiver(t *testing.T) {
+	srv := httptest.NewServer(&fakeIMDS{paths: map[string]string{scheduledPath: "[]"}})
+	t.Cleanup(srv.Close)
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig()
+	cfg.(*Config).Endpoint = srv.URL
+	set := componenttest.NewNopReceiverCreateSettings()
+
+	logsReceiver, err := factory.CreateLogsReceiver(context.Background(), set, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	metricsReceiver, err := factory.CreateMetricsReceiver(context.Background(), set, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	shared := logsReceiver.(*receiverHandle).receiver
+	assert.Same(t, shared, metricsReceiver.(*receiverHandle).receiver)
+	assert.NotNil(t, shared.logsConsumer)
+	assert.NotNil(t, shared.metricsConsumer)
+
+	host := componenttest.NewNopHost()
+	require.NoError(t, logsReceiver.Start(context.Background(), host))
+	require.NoError(t, metricsReceiver.Start(context.Background(), host))
+
+	// Shutting one pipeline down, even twice, keeps the receiver polling for the other.
+	require.NoError(t, logsReceiver.Shutdown(context.Background()))
+	require.NoError(t, logsReceiver.Shutdown(context.Background()))
+	select {
+	case <-shared.stopCh:
+		t.Fatal("the receiver should keep running while a pipeline uses it")
+	default:
+	}
+	require.NoError(t, metricsReceiver.Shutdown(context.Background()))
+	<-shared.stopCh
+
+	// A receiver created after the shutdown is a fresh one.
+	logsReceiver, err = factory.CreateLogsReceiver(context.Background(), set, cfg, consumertest.NewNop())
+	require.NoError(t, err)
+	assert.NotSame(t, shared, logsReceiver.(*receiverHandle).receiver)
+	require.NoError(t, logsReceiver.Shutdown(context.Background()))
+}